@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
 
@@ -8,14 +9,116 @@ import (
 )
 
 type Config struct {
-	TgToken       string `yaml:"telegram-token"`
-	DeepSeekToken string `yaml:"deepseek-token"`
-	BaseURL       string `yaml:"base-url"`
-	DeepSeekModel string `yaml:"deepseek-model"`
-	AesKey        string `yaml:"aes"`
-	Debug         bool   `yaml:"debug-mode"`
+	TgToken          string                    `yaml:"telegram-token"`
+	DeepSeekToken    string                    `yaml:"deepseek-token"`
+	BaseURL          string                    `yaml:"base-url"`
+	DeepSeekModel    string                    `yaml:"deepseek-model"`
+	AesKey           string                    `yaml:"aes"`
+	Debug            bool                      `yaml:"debug-mode"`
+	Provider         string                    `yaml:"provider" env-default:"deepseek"`      // Name of the provider that answers users
+	Providers        map[string]ProviderConfig `yaml:"providers"`                            // Additional neural network backends
+	Fallback         []FallbackEntry           `yaml:"fallback"`                             // Models tried in order when the main provider fails
+	MaxRetries       *int                      `yaml:"max-retries"`                          // Repeats of a request after a rate limit or server error, 3 if missing, 0 disables retries
+	Breaker          BreakerConfig             `yaml:"circuit-breaker"`                      // Fail fast while a provider is down
+	SystemPrompt     string                    `yaml:"system-prompt"`                        // Instructions sent with every request, a built-in prompt is used if empty
+	ContextTokens    int                       `yaml:"context-tokens" env-default:"16000"`   // Token budget of the history sent to the model
+	HistoryLimit     int                       `yaml:"history-limit" env-default:"100"`      // Maximum number of stored messages considered for the history
+	SummaryAfter     int                       `yaml:"summary-after" env-default:"20"`       // Unsummarized messages that trigger condensing older turns, -1 disables summaries (0 means the default)
+	SummaryKeep      int                       `yaml:"summary-keep" env-default:"10"`        // Newest messages that are never condensed
+	Quota            QuotaConfig               `yaml:"quota"`                                // Token limits per user
+	AttemptTimeout   int                       `yaml:"attempt-timeout" env-default:"60"`     // Seconds a streamed answer may stay silent before the next model is tried
+	CodeFileSize     int                       `yaml:"code-file-size" env-default:"3000"`    // Code blocks longer than this many characters are sent as files, -1 disables it (0 means the default)
+	AttachmentTokens int                       `yaml:"attachment-tokens" env-default:"8000"` // Token budget of the files attached to a conversation, a part of context-tokens
+	Vision           []FallbackEntry           `yaml:"vision"`                               // Models that answer photos, tried in order. Photos are not accepted if empty
+	Tools            ToolsConfig               `yaml:"tools"`                                // Functions the model can call: calculator, date and time, unit conversion
+	Speech           SpeechConfig              `yaml:"speech"`                               // Speech recognition for voice messages
 }
 
+type ToolsConfig struct { // Pointers tell a missing key from false and 0, cleanenv would replace those with env-default
+	Enabled       *bool  `yaml:"enabled"`                    // Models without function calling need it turned off, on if missing
+	Timezone      string `yaml:"timezone" env-default:"UTC"` // IANA time zone of users who have not set their own with /timezone
+	MaxIterations *int   `yaml:"max-iterations"`             // Rounds of tool calls before the model has to answer, 5 if missing
+}
+
+const defaultToolIterations = 5
+
+func (t ToolsConfig) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+func (t ToolsConfig) Iterations() int {
+	if t.MaxIterations == nil {
+		return defaultToolIterations
+	}
+	return *t.MaxIterations
+}
+
+type SpeechConfig struct {
+	Type        string `yaml:"type"`     // openai or whispercpp, empty disables voice messages
+	BaseURL     string `yaml:"base-url"` // Server URL, a default for the type is used if empty
+	Token       string `yaml:"token"`
+	Model       string `yaml:"model" env-default:"whisper-1"`
+	Language    string `yaml:"language"`                       // ISO 639-1 code of the speech, empty lets the server detect it
+	MaxDuration int    `yaml:"max-duration" env-default:"300"` // Longest voice message in seconds
+}
+
+type BreakerConfig struct {
+	Enabled      *bool   `yaml:"enabled"`                         // On if missing, a pointer keeps enabled: false from env-default
+	FailureRatio float64 `yaml:"failure-ratio" env-default:"0.5"` // Share of failed requests that opens the circuit
+	MinRequests  int     `yaml:"min-requests" env-default:"5"`    // Requests in the window before the ratio is checked
+	Window       int     `yaml:"window" env-default:"60"`         // Seconds over which requests are counted
+	OpenTimeout  int     `yaml:"open-timeout" env-default:"30"`   // Seconds before probe requests are let through
+	Probes       int     `yaml:"probes" env-default:"2"`          // Successful probes needed to close the circuit
+}
+
+func (b BreakerConfig) IsEnabled() bool {
+	return b.Enabled == nil || *b.Enabled
+}
+
+type QuotaConfig struct {
+	DefaultTier string               `yaml:"default-tier" env-default:"free"` // Tier of users not listed in Users
+	Tiers       map[string]QuotaTier `yaml:"tiers"`                           // No tiers means no limits
+	Users       map[int64]string     `yaml:"users"`                           // Telegram ID -> tier
+}
+
+type QuotaTier struct { // Tokens (prompt + answer) a user may spend, 0 means unlimited
+	Daily   int64 `yaml:"daily"`
+	Monthly int64 `yaml:"monthly"`
+}
+
+// TierOf returns the name and limits of the user's tier.
+func (q QuotaConfig) TierOf(userID int64) (string, QuotaTier) {
+	name, ok := q.Users[userID]
+	if !ok {
+		name = q.DefaultTier
+	}
+	return name, q.Tiers[name]
+}
+
+type FallbackEntry struct {
+	Provider string `yaml:"provider"` // Name of a provider, see LookupProvider
+	Model    string `yaml:"model"`    // Empty means the model from the provider settings
+}
+
+type ProviderConfig struct {
+	Type    string `yaml:"type"`     // deepseek, openai, ollama or llamacpp. Defaults to the provider name
+	BaseURL string `yaml:"base-url"` // API URL, a default for the type is used if empty
+	Token   string `yaml:"token"`
+	Model   string `yaml:"model"`
+}
+
+const ( // Supported provider types
+	ProviderDeepSeek = "deepseek"
+	ProviderOpenAI   = "openai" // Any API compatible with OpenAI chat completions
+	ProviderOllama   = "ollama"
+	ProviderLlamaCpp = "llamacpp"
+)
+
+const ( // Supported speech recognition types
+	SpeechOpenAI     = "openai"     // OpenAI or any server with a compatible /audio/transcriptions endpoint
+	SpeechWhisperCpp = "whispercpp" // whisper.cpp server
+)
+
 var AES_KEY string // message encryption key
 
 func Load() *Config {
@@ -34,3 +137,33 @@ func Load() *Config {
 
 	return &conf
 }
+
+const defaultMaxRetries = 3
+
+// Retries returns max-retries, a pointer keeps an explicit 0 that cleanenv would replace with env-default.
+func (c *Config) Retries() int {
+	if c.MaxRetries == nil {
+		return defaultMaxRetries
+	}
+	return *c.MaxRetries
+}
+
+// LookupProvider returns the settings of the provider with the given name.
+// The "deepseek" provider is built from the top-level deepseek-token, base-url and deepseek-model keys unless it is listed in providers.
+func (c *Config) LookupProvider(name string) (ProviderConfig, error) {
+	pc, ok := c.Providers[name]
+	if !ok {
+		if name != ProviderDeepSeek {
+			return ProviderConfig{}, fmt.Errorf("[ config.go ] provider %q is not configured", name)
+		}
+		pc = ProviderConfig{
+			BaseURL: c.BaseURL,
+			Token:   c.DeepSeekToken,
+			Model:   c.DeepSeekModel,
+		}
+	}
+	if pc.Type == "" {
+		pc.Type = name
+	}
+	return pc, nil
+}
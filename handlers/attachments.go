@@ -0,0 +1,87 @@
+// Tasks: Files attached to a conversation, their text is sent with every request of it.
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"quokka-ai-bot/models"
+	"quokka-ai-bot/utils"
+	"time"
+)
+
+var ErrAttachmentTooLarge = errors.New("attachment does not fit into the token budget")
+
+// AddAttachment attaches the text of a file to the current conversation of the dialog and returns its estimated size in tokens.
+// A file larger than the attachment budget is rejected with ErrAttachmentTooLarge.
+func (h *NeuralHandler) AddAttachment(ctx context.Context, d Dialog, fileName, content string) (int, error) {
+	tokens := h.Estimator.EstimateTokens(content)
+	if tokens > h.AttachmentTokens {
+		return tokens, fmt.Errorf("%w: %d tokens, limit %d", ErrAttachmentTooLarge, tokens, h.AttachmentTokens)
+	}
+	conversationID, err := h.conversationOf(ctx, d)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get active conversation: %w", err)
+	}
+	aesName, err := utils.EncryptMessage(fileName)
+	if err != nil {
+		return 0, err
+	}
+	aesContent, err := utils.EncryptMessage(content)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	_, err = h.DB.ExecContext(ctx,
+		"INSERT INTO conversation_attachments (conversation_id, user_id, file_name, content, tokens, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		conversationID, d.UserID, aesName, aesContent, tokens, now)
+	if err != nil {
+		return 0, err
+	}
+	_, err = h.DB.ExecContext(ctx, "UPDATE conversations SET updated_at = $1 WHERE id = $2", now, conversationID)
+	return tokens, err
+}
+
+// attachmentMessages returns the attachments of the conversation as system messages, oldest first.
+// Older files are left out once the newer ones use up the attachment budget.
+func (h *NeuralHandler) attachmentMessages(ctx context.Context, conversationID int64) ([]models.Message, error) {
+	rows, err := h.DB.QueryContext(ctx,
+		"SELECT file_name, content, tokens FROM conversation_attachments WHERE conversation_id = $1 ORDER BY id DESC",
+		conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	budget := h.AttachmentTokens
+	for rows.Next() {
+		var name, content string
+		var tokens int
+		if err := rows.Scan(&name, &content, &tokens); err != nil {
+			return nil, fmt.Errorf("row scan failed: %w", err)
+		}
+		if tokens > budget {
+			break
+		}
+		budget -= tokens
+		if name, err = utils.DecryptMessage(name); err != nil {
+			return nil, fmt.Errorf("decryption failed: %w", err)
+		}
+		if content, err = utils.DecryptMessage(content); err != nil {
+			return nil, fmt.Errorf("decryption failed: %w", err)
+		}
+		messages = append(messages, models.Message{
+			Role:    "system",
+			Content: fmt.Sprintf("The user attached the file %q to this conversation. Its content:\n\"\"\"\n%s\n\"\"\"", name, content),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
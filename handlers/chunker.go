@@ -0,0 +1,224 @@
+// Tasks: Splitting long answers into several Telegram messages.
+package handlers
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+const messageLimit = 4096 // Maximum length of a Telegram message, counted in UTF-16 code units
+
+type chunkPart struct {
+	text string
+	sep  string // Joins the part to the previous one when both end up in the same message
+}
+
+// splitMessage splits the text into messages of at most limit characters.
+// Paragraphs are kept whole when possible, then sentences, and only then the text is cut between characters.
+// Code blocks are never left open: a block that does not fit is closed at the end of a message and reopened in the next one.
+func splitMessage(text string, limit int) []string {
+	text = strings.TrimSpace(text)
+	if textLength(text) <= limit {
+		return []string{text}
+	}
+
+	var parts []chunkPart
+	for i, block := range splitBlocks(text) {
+		sep := "\n\n"
+		if i == 0 {
+			sep = ""
+		}
+		var blockParts []chunkPart
+		switch {
+		case textLength(block.text) <= limit:
+			blockParts = []chunkPart{{text: block.text}}
+		case block.fence != "":
+			blockParts = splitCode(block, limit)
+		default:
+			blockParts = splitParagraph(block.text, limit)
+		}
+		blockParts[0].sep = sep
+		parts = append(parts, blockParts...)
+	}
+
+	var (
+		chunks []string
+		cur    strings.Builder
+		size   int
+	)
+	flush := func() {
+		if chunk := strings.TrimSpace(cur.String()); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		cur.Reset()
+		size = 0
+	}
+	for _, part := range parts {
+		partSize := textLength(part.text)
+		if size > 0 && size+textLength(part.sep)+partSize > limit {
+			flush()
+		}
+		if size > 0 {
+			cur.WriteString(part.sep)
+			size += textLength(part.sep)
+		}
+		cur.WriteString(part.text)
+		size += partSize
+	}
+	flush()
+	return chunks
+}
+
+type textBlock struct {
+	text  string
+	fence string   // Opening line of a code block, empty for a paragraph
+	lines []string // Lines of a code block between the fences
+}
+
+func splitBlocks(text string) []textBlock { // Splits the text into paragraphs and code blocks, blank lines inside code blocks are kept
+	var (
+		blocks    []textBlock
+		paragraph []string
+		code      *textBlock
+	)
+	endParagraph := func() {
+		if len(paragraph) > 0 {
+			blocks = append(blocks, textBlock{text: strings.Join(paragraph, "\n")})
+			paragraph = nil
+		}
+	}
+	for _, line := range strings.Split(text, "\n") {
+		isFence := strings.HasPrefix(strings.TrimSpace(line), "```")
+		switch {
+		case code != nil && isFence:
+			code.text = strings.Join(append(append([]string{code.fence}, code.lines...), line), "\n")
+			blocks = append(blocks, *code)
+			code = nil
+		case code != nil:
+			code.lines = append(code.lines, line)
+		case isFence:
+			endParagraph()
+			code = &textBlock{fence: line}
+		case strings.TrimSpace(line) == "":
+			endParagraph()
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	endParagraph()
+	if code != nil { // The model did not close the last code block
+		code.text = strings.Join(append([]string{code.fence}, code.lines...), "\n")
+		blocks = append(blocks, *code)
+	}
+	return blocks
+}
+
+func splitCode(block textBlock, limit int) []chunkPart { // Splits a code block by lines, every part is a closed code block
+	const closing = "\n```"
+	budget := max(limit-textLength(block.fence)-textLength(closing)-1, 1)
+
+	var (
+		parts []chunkPart
+		cur   []string
+		size  int
+	)
+	flush := func() {
+		if len(cur) > 0 {
+			parts = append(parts, chunkPart{text: block.fence + "\n" + strings.Join(cur, "\n") + closing, sep: "\n"})
+			cur, size = nil, 0
+		}
+	}
+	for _, line := range block.lines {
+		pieces := []string{line}
+		if textLength(line) > budget {
+			pieces = splitRunes(line, budget)
+		}
+		for _, piece := range pieces {
+			pieceSize := textLength(piece) + 1 // Together with the line break
+			if size+pieceSize > budget {
+				flush()
+			}
+			cur = append(cur, piece)
+			size += pieceSize
+		}
+	}
+	flush()
+	if len(parts) == 0 {
+		return []chunkPart{{text: block.text}}
+	}
+	return parts
+}
+
+func splitParagraph(paragraph string, limit int) []chunkPart { // Splits a paragraph into lines and sentences, overlong sentences are cut
+	var parts []chunkPart
+	add := func(sentence, sep string) {
+		if textLength(sentence) <= limit {
+			parts = append(parts, chunkPart{text: sentence, sep: sep})
+			return
+		}
+		for i, piece := range splitRunes(sentence, limit) {
+			if i > 0 {
+				sep = ""
+			}
+			parts = append(parts, chunkPart{text: piece, sep: sep})
+		}
+	}
+
+	runes := []rune(paragraph)
+	start, sep := 0, ""
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '\n' && !(strings.ContainsRune(".!?…", runes[i]) && i+1 < len(runes) && unicode.IsSpace(runes[i+1])) {
+			continue
+		}
+		end := i + 1
+		if runes[i] == '\n' {
+			end = i
+		}
+		next := end // Whitespace after the sentence becomes the separator of the next one
+		for next < len(runes) && unicode.IsSpace(runes[next]) {
+			next++
+		}
+		add(string(runes[start:end]), sep)
+		sep = string(runes[end:next])
+		start, i = next, next-1
+	}
+	if start < len(runes) {
+		add(string(runes[start:]), sep)
+	}
+	return parts
+}
+
+func splitRunes(text string, limit int) []string { // Cuts the text into pieces of at most limit characters, preferably at a space
+	var pieces []string
+	runes := []rune(text)
+	for len(runes) > 0 {
+		end, size := 0, 0
+		for end < len(runes) && size+utf16.RuneLen(runes[end]) <= limit {
+			size += utf16.RuneLen(runes[end])
+			end++
+		}
+		if end == 0 { // The limit is smaller than one character
+			end = 1
+		}
+		if end < len(runes) {
+			for i := end - 1; i > end/2; i-- {
+				if unicode.IsSpace(runes[i]) {
+					end = i + 1
+					break
+				}
+			}
+		}
+		pieces = append(pieces, string(runes[:end]))
+		runes = runes[end:]
+	}
+	return pieces
+}
+
+func textLength(text string) int { // Length of the text as Telegram counts it
+	n := 0
+	for _, r := range text {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "short text",
+			text:  "  Hello, world!  \n",
+			limit: 100,
+			want:  []string{"Hello, world!"},
+		},
+		{
+			name:  "paragraphs kept whole",
+			text:  "First paragraph.\n\nSecond paragraph.\n\nThird one.",
+			limit: 36,
+			want:  []string{"First paragraph.\n\nSecond paragraph.", "Third one."},
+		},
+		{
+			name:  "sentences of a long paragraph",
+			text:  "One two three. Four five six! Seven eight nine?",
+			limit: 20,
+			want:  []string{"One two three.", "Four five six!", "Seven eight nine?"},
+		},
+		{
+			name:  "overlong word is cut",
+			text:  "abcdefghij",
+			limit: 4,
+			want:  []string{"abcd", "efgh", "ij"},
+		},
+		{
+			name:  "code block reopened",
+			text:  "```go\nline one\nline two\nline three\n```",
+			limit: 25,
+			want:  []string{"```go\nline one\n```", "```go\nline two\n```", "```go\nline three\n```"},
+		},
+		{
+			name:  "unclosed code block",
+			text:  "```\naaaa\nbbbb",
+			limit: 12,
+			want:  []string{"```\naaaa\n```", "```\nbbbb\n```"},
+		},
+		{
+			name:  "emoji count as two UTF-16 units",
+			text:  "😀😀😀😀😀",
+			limit: 4,
+			want:  []string{"😀😀", "😀😀", "😀"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.text, tt.limit)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("splitMessage(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+			for _, chunk := range got {
+				if n := textLength(chunk); n > tt.limit {
+					t.Errorf("chunk %q is %d long, limit %d", chunk, n, tt.limit)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitMessageKeepsFencesBalanced(t *testing.T) {
+	text := "Intro text.\n\n```python\n" + strings.Repeat("print('привет, мир') # 🐍\n", 300) + "```\n\nOutro."
+	chunks := splitMessage(text, messageLimit)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want the code block split", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if n := textLength(chunk); n > messageLimit {
+			t.Errorf("chunk %d is %d long", i, n)
+		}
+		if strings.Count(chunk, "```")%2 != 0 {
+			t.Errorf("chunk %d leaves a code block open:\n%s", i, chunk)
+		}
+		if strings.Contains(chunk, "print(") && !strings.Contains(chunk, "```python\n") {
+			t.Errorf("chunk %d continues the code without reopening the block", i)
+		}
+	}
+}
+
+func TestTextLength(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"привет", 6},
+		{"😀", 2},
+		{"a😀b", 4},
+	}
+	for _, tt := range tests {
+		if got := textLength(tt.text); got != tt.want {
+			t.Errorf("textLength(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Tasks: Moving large code blocks of answers into files.
+package handlers
+
+import (
+	"fmt"
+	"quokka-ai-bot/i18n"
+	"strings"
+)
+
+type codeFile struct {
+	Name    string
+	Content string
+}
+
+var codeFileNames = map[string]string{ // Fence language -> file name
+	"go":         "main.go",
+	"golang":     "main.go",
+	"python":     "script.py",
+	"py":         "script.py",
+	"javascript": "script.js",
+	"js":         "script.js",
+	"typescript": "script.ts",
+	"ts":         "script.ts",
+	"tsx":        "component.tsx",
+	"jsx":        "component.jsx",
+	"java":       "Main.java",
+	"kotlin":     "Main.kt",
+	"c":          "main.c",
+	"cpp":        "main.cpp",
+	"c++":        "main.cpp",
+	"csharp":     "Program.cs",
+	"cs":         "Program.cs",
+	"rust":       "main.rs",
+	"php":        "index.php",
+	"ruby":       "script.rb",
+	"swift":      "main.swift",
+	"bash":       "script.sh",
+	"sh":         "script.sh",
+	"shell":      "script.sh",
+	"powershell": "script.ps1",
+	"sql":        "query.sql",
+	"html":       "index.html",
+	"css":        "style.css",
+	"json":       "data.json",
+	"yaml":       "config.yaml",
+	"yml":        "config.yaml",
+	"toml":       "config.toml",
+	"xml":        "data.xml",
+	"dockerfile": "Dockerfile",
+	"makefile":   "Makefile",
+	"lua":        "script.lua",
+	"markdown":   "README.md",
+	"md":         "README.md",
+}
+
+// extractCodeFiles replaces code blocks longer than limit characters with a reference to a file in lang and returns the files.
+// The text is returned unchanged if there is nothing to extract.
+func extractCodeFiles(lang, text string, limit int) (string, []codeFile) {
+	if limit <= 0 {
+		return text, nil
+	}
+	blocks := splitBlocks(strings.TrimSpace(text))
+	var (
+		files []codeFile
+		parts = make([]string, 0, len(blocks))
+		taken = make(map[string]int) // How many files got the same name
+	)
+	for _, block := range blocks {
+		code := strings.Join(block.lines, "\n")
+		if block.fence == "" || textLength(code) <= limit {
+			parts = append(parts, block.text)
+			continue
+		}
+		name := codeFileName(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(block.fence), "```")))
+		if taken[name]++; taken[name] > 1 {
+			ext := strings.LastIndexByte(name, '.')
+			if ext <= 0 {
+				ext = len(name)
+			}
+			name = fmt.Sprintf("%s_%d%s", name[:ext], taken[name], name[ext:])
+		}
+		files = append(files, codeFile{Name: name, Content: code + "\n"})
+		parts = append(parts, i18n.T(lang, "answer.code_file", name))
+	}
+	if len(files) == 0 {
+		return text, nil
+	}
+	return strings.Join(parts, "\n\n"), files
+}
+
+func codeFileName(lang string) string {
+	if name, ok := codeFileNames[strings.ToLower(lang)]; ok {
+		return name
+	}
+	return "code.txt"
+}
@@ -0,0 +1,261 @@
+// Tasks: Several named conversations per user, switching between them and their automatic titles.
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"quokka-ai-bot/i18n"
+	"quokka-ai-bot/models"
+	"quokka-ai-bot/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+const titlePrompt = `Write a short title (at most 6 words) for the conversation below, in its language.
+Reply with the title only, without quotes or punctuation at the end.`
+
+var ErrConversationNotFound = errors.New("conversation not found")
+
+type Conversation struct {
+	ID        int64
+	Title     string // Empty until it is generated
+	Active    bool
+	UpdatedAt time.Time
+}
+
+// activeConversation returns the conversation new messages of the user go to, creating the first one if needed.
+func (h *NeuralHandler) activeConversation(ctx context.Context, userID int64) (int64, error) {
+	var id int64
+	err := h.DB.QueryRowContext(ctx, "SELECT conversation_id FROM active_conversations WHERE user_id = $1", userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return h.NewConversation(ctx, userID, "")
+	}
+	return id, err
+}
+
+// NewConversation creates a conversation and makes it active. The title is generated later if empty.
+func (h *NeuralHandler) NewConversation(ctx context.Context, userID int64, title string) (int64, error) {
+	var encTitle sql.NullString
+	if title != "" {
+		enc, err := utils.EncryptMessage(title)
+		if err != nil {
+			return 0, err
+		}
+		encTitle = sql.NullString{String: enc, Valid: true}
+	}
+
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO conversations (user_id, title, created_at, updated_at) VALUES ($1, $2, $3, $3) RETURNING id",
+		userID, encTitle, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if err := setActive(ctx, tx, userID, id); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func setActive(ctx context.Context, tx *sql.Tx, userID, conversationID int64) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO active_conversations (user_id, conversation_id) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET conversation_id = EXCLUDED.conversation_id`,
+		userID, conversationID)
+	return err
+}
+
+// Conversations returns the most recently used conversations of the user.
+func (h *NeuralHandler) Conversations(ctx context.Context, userID int64, limit int) ([]Conversation, error) {
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT c.id, c.title, c.updated_at, a.conversation_id IS NOT NULL
+		FROM conversations c
+		LEFT JOIN active_conversations a ON a.conversation_id = c.id
+		WHERE c.user_id = $1
+		ORDER BY c.updated_at DESC
+		LIMIT $2`,
+		userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+	for rows.Next() {
+		var (
+			conv  Conversation
+			title sql.NullString
+		)
+		if err := rows.Scan(&conv.ID, &title, &conv.UpdatedAt, &conv.Active); err != nil {
+			return nil, fmt.Errorf("row scan failed: %w", err)
+		}
+		if title.Valid {
+			if conv.Title, err = utils.DecryptMessage(title.String); err != nil {
+				return nil, fmt.Errorf("decryption failed: %w", err)
+			}
+		}
+		conversations = append(conversations, conv)
+	}
+	return conversations, rows.Err()
+}
+
+// SwitchConversation makes the conversation active if it belongs to the user.
+func (h *NeuralHandler) SwitchConversation(ctx context.Context, userID, conversationID int64) error {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var owner int64
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM conversations WHERE id = $1", conversationID).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != userID) {
+		return ErrConversationNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := setActive(ctx, tx, userID, conversationID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// generateTitle asks the model for a title of a conversation that has none yet, using its first exchange.
+func (h *NeuralHandler) generateTitle(ctx context.Context, userID, conversationID int64) error {
+	var hasTitle bool
+	err := h.DB.QueryRowContext(ctx, "SELECT title IS NOT NULL FROM conversations WHERE id = $1", conversationID).Scan(&hasTitle)
+	if err != nil || hasTitle {
+		return err
+	}
+	history, err := h.firstMessages(ctx, conversationID, 2) // The first question and answer are enough for a title
+	if err != nil || len(history) == 0 {
+		return err
+	}
+
+	var input strings.Builder
+	for _, msg := range history {
+		content, _ := truncateRunes(msg.Content, summaryMessageLimit)
+		fmt.Fprintf(&input, "%s: %s\n\n", msg.Role, content)
+	}
+	response, route, err := h.complete(ctx, models.ChatRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: titlePrompt},
+			{Role: "user", Content: input.String()},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	title, _ := truncateRunes(strings.Trim(strings.TrimSpace(response.Content), `"«»`), 64)
+	if title == "" {
+		return errors.New("empty title")
+	}
+	encTitle, err := utils.EncryptMessage(title)
+	if err != nil {
+		return err
+	}
+	if _, err := h.DB.ExecContext(ctx, "UPDATE conversations SET title = $1 WHERE id = $2 AND title IS NULL", encTitle, conversationID); err != nil {
+		return err
+	}
+	return h.saveUsage(ctx, userID, 0, route.String(), response.Usage)
+}
+
+const chatsListLimit = 10 // Conversations shown by /chats
+
+func (h *TelegramHandler) messageNew(lang string, user *telebot.User, title string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	title, _ = truncateRunes(strings.TrimSpace(title), 64)
+	if _, err := h.Neural.NewConversation(ctx, user.ID, title); err != nil {
+		h.Logger.Printf("[ ERROR ] New conversation error for user %d %s: %v", user.ID, user.Username, err)
+		return i18n.T(lang, "chats.new_failed")
+	}
+	if title != "" {
+		return i18n.T(lang, "chats.new_titled", html.EscapeString(title))
+	}
+	return i18n.T(lang, "chats.new")
+}
+
+func (h *TelegramHandler) sendChats(c telebot.Context) error {
+	text, markup := h.messageChats(h.lang(c), c.Sender())
+	if markup == nil {
+		return c.Send(text, telebot.ModeHTML)
+	}
+	return c.Send(text, markup, telebot.ModeHTML)
+}
+
+func (h *TelegramHandler) messageChats(lang string, user *telebot.User) (string, *telebot.ReplyMarkup) { // The list of conversations with a button for each of them
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	conversations, err := h.Neural.Conversations(ctx, user.ID, chatsListLimit)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Conversations error for user %d %s: %v", user.ID, user.Username, err)
+		return i18n.T(lang, "chats.failed"), nil
+	}
+	if len(conversations) == 0 {
+		return i18n.T(lang, "chats.empty"), nil
+	}
+
+	var text strings.Builder
+	text.WriteString(i18n.T(lang, "chats.header"))
+	markup := &telebot.ReplyMarkup{}
+	rows := make([]telebot.Row, 0, len(conversations))
+	for i, conv := range conversations {
+		title := conversationTitle(lang, conv)
+		mark := ""
+		if conv.Active {
+			mark = "✅ "
+		}
+		fmt.Fprintf(&text, "%d. %s%s\n", i+1, mark, html.EscapeString(title))
+		rows = append(rows, markup.Row(markup.Data(mark+title, chatButton, strconv.FormatInt(conv.ID, 10))))
+	}
+	text.WriteString(i18n.T(lang, "chats.footer"))
+	markup.Inline(rows...)
+	return text.String(), markup
+}
+
+func conversationTitle(lang string, conv Conversation) string {
+	if conv.Title != "" {
+		return conv.Title
+	}
+	return i18n.T(lang, "chats.untitled", conv.UpdatedAt.Format("02.01 15:04"))
+}
+
+func (h *TelegramHandler) messageSwitch(lang string, user *telebot.User, payload string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	number, err := strconv.Atoi(strings.TrimSpace(payload))
+	if err != nil || number < 1 {
+		return i18n.T(lang, "chats.switch_usage")
+	}
+	conversations, err := h.Neural.Conversations(ctx, user.ID, chatsListLimit)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Conversations error for user %d %s: %v", user.ID, user.Username, err)
+		return i18n.T(lang, "chats.switch_failed")
+	}
+	if number > len(conversations) {
+		return i18n.T(lang, "chats.switch_not_found")
+	}
+	conv := conversations[number-1]
+	if err := h.Neural.SwitchConversation(ctx, user.ID, conv.ID); err != nil {
+		h.Logger.Printf("[ ERROR ] Switch error for user %d %s: %v", user.ID, user.Username, err)
+		return i18n.T(lang, "chats.switch_failed")
+	}
+	return i18n.T(lang, "chats.switched_to", html.EscapeString(conversationTitle(lang, conv)))
+}
@@ -0,0 +1,132 @@
+// Tasks: Receiving text, code and PDF files, their text is attached to the conversation so questions can refer to it.
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+	"gopkg.in/telebot.v4"
+)
+
+const documentFileLimit = 20 << 20 // Bytes, bots cannot download larger files from Telegram
+
+var errNotText = errors.New("file is not a text file")
+
+var documentExtensions = map[string]bool{ // Files accepted besides any text/* MIME type
+	".txt": true, ".md": true, ".csv": true, ".pdf": true, ".log": true,
+	".go": true, ".py": true, ".js": true, ".ts": true, ".tsx": true, ".jsx": true,
+	".java": true, ".kt": true, ".c": true, ".h": true, ".cpp": true, ".hpp": true,
+	".cs": true, ".rs": true, ".php": true, ".rb": true, ".swift": true, ".sh": true,
+	".ps1": true, ".sql": true, ".html": true, ".css": true, ".json": true, ".yaml": true,
+	".yml": true, ".toml": true, ".xml": true, ".lua": true, ".ini": true, ".env": true,
+}
+
+func (h *TelegramHandler) HandleDocument(c telebot.Context) error {
+	user := c.Sender()
+	msg := c.Message()
+	doc := msg.Document
+	caption := strings.TrimSpace(msg.Caption)
+	if !msg.Private() {
+		if h.mention != nil && h.mention.MatchString(caption) {
+			caption = strings.TrimSpace(h.mention.ReplaceAllString(caption, ""))
+		} else if !h.groupAddressed(c) { // In groups the bot takes only files addressed to it
+			return nil
+		}
+	}
+
+	if !documentSupported(doc) {
+		return c.Send(h.text(c, "documents.unsupported"))
+	}
+	if doc.FileSize > documentFileLimit {
+		return c.Send(h.text(c, "documents.too_large"))
+	}
+	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+	} else if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.file", waitTime.Seconds()))
+	}
+	h.Logger.Printf("Document from %d %s in %d: %s (%d bytes)", user.ID, user.Username, c.Chat().ID, doc.FileName, doc.FileSize)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	text, err := h.documentText(doc)
+	switch {
+	case errors.Is(err, errNotText):
+		return c.Send(h.text(c, "documents.not_text"))
+	case err != nil:
+		h.Logger.Printf("[ ERROR ] Failed to read document of %d: %v", user.ID, err)
+		return c.Send(h.text(c, "documents.read_failed"))
+	case strings.TrimSpace(text) == "":
+		return c.Send(h.text(c, "documents.empty"))
+	}
+
+	tokens, err := h.Neural.AddAttachment(ctx, h.dialogOf(ctx, c), doc.FileName, text)
+	if errors.Is(err, ErrAttachmentTooLarge) {
+		return c.Send(h.text(c, "documents.too_long", tokens, h.Neural.AttachmentTokens))
+	}
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to attach document of %d: %v", user.ID, err)
+		return c.Send(h.text(c, "documents.save_failed"))
+	}
+
+	if caption != "" { // The caption is the first question about the file
+		return h.handleRequest(c, caption)
+	}
+	return c.Send(h.text(c, "documents.attached", html.EscapeString(doc.FileName), tokens), telebot.ModeHTML)
+}
+
+func documentSupported(doc *telebot.Document) bool {
+	return documentExtensions[strings.ToLower(filepath.Ext(doc.FileName))] || strings.HasPrefix(doc.MIME, "text/")
+}
+
+func (h *TelegramHandler) documentText(doc *telebot.Document) (string, error) { // Downloads the file and extracts its text
+	reader, err := h.Bot.File(&doc.File)
+	if err != nil {
+		return "", fmt.Errorf("error downloading file: %w", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, documentFileLimit))
+	if err != nil {
+		return "", fmt.Errorf("error downloading file: %w", err)
+	}
+	if strings.ToLower(filepath.Ext(doc.FileName)) == ".pdf" || doc.MIME == "application/pdf" {
+		return pdfText(data)
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // Byte order mark of files saved on Windows
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return "", errNotText
+	}
+	return string(data), nil
+}
+
+func pdfText(data []byte) (text string, err error) {
+	defer func() { // The parser panics on some broken files
+		if r := recover(); r != nil {
+			err = fmt.Errorf("broken PDF: %v", r)
+		}
+	}()
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("error opening PDF: %w", err)
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", fmt.Errorf("error reading PDF: %w", err)
+	}
+	var b strings.Builder
+	if _, err := io.Copy(&b, plain); err != nil {
+		return "", fmt.Errorf("error reading PDF: %w", err)
+	}
+	return strings.ToValidUTF8(b.String(), ""), nil
+}
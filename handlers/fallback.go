@@ -0,0 +1,84 @@
+// Tasks: Walking through the chain of models until one of them answers.
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"quokka-ai-bot/models"
+	"sync/atomic"
+	"time"
+)
+
+// complete asks the models of the chain in order and returns the answer of the first one that succeeds
+// together with the route that produced it. The next model is tried only after a retryable failure.
+func (h *NeuralHandler) complete(ctx context.Context, request models.ChatRequest) (models.Completion, models.ModelRoute, error) {
+	return h.completeOn(ctx, h.Chain, request)
+}
+
+func (h *NeuralHandler) completeOn(ctx context.Context, chain []models.ModelRoute, request models.ChatRequest) (models.Completion, models.ModelRoute, error) { // complete with another chain, such as the vision models
+	var lastErr error
+	for i, route := range chain {
+		req := request
+		req.Model = route.Model
+		response, err := route.Provider.ChatCompletion(ctx, req) // Only the caller limits the time: a long answer is not silence, and asking another model bills it again
+		if err == nil {
+			return response, route, nil
+		}
+		lastErr = fmt.Errorf("%s api error: %w", route, err)
+		if !shouldFallback(ctx, err, i, len(chain)) {
+			break
+		}
+		log.Printf("[ WARN ] %v, trying the next model", lastErr)
+	}
+	return models.Completion{}, models.ModelRoute{}, lastErr
+}
+
+// completeStreamOn works like completeOn for streamed answers. Once a model has started sending text,
+// its failure is final: the user has already seen part of the answer.
+func (h *NeuralHandler) completeStreamOn(ctx context.Context, chain []models.ModelRoute, request models.ChatRequest, onDelta func(delta string)) (models.Completion, models.ModelRoute, error) {
+	var lastErr error
+	for i, route := range chain {
+		var started atomic.Bool
+		attemptCtx, cancel := context.WithCancelCause(ctx)
+		// The timeout restarts with every piece of text, so long answers are not cut off while they keep coming.
+		idle := time.AfterFunc(h.AttemptTimeout, func() { cancel(context.DeadlineExceeded) })
+		req := request
+		req.Model = route.Model
+		req.OnReasoning = func(string) { // A reasoning model thinks for a long time before the answer, that is not silence.
+			idle.Reset(h.AttemptTimeout) // Nothing has been shown yet, so a failure while thinking still falls back
+		}
+		response, err := route.Provider.ChatCompletionStream(attemptCtx, req, func(delta string) {
+			started.Store(true)
+			idle.Reset(h.AttemptTimeout)
+			onDelta(delta)
+		})
+		idle.Stop()
+		if err != nil && errors.Is(err, context.Canceled) && errors.Is(context.Cause(attemptCtx), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+		}
+		cancel(nil)
+		if err == nil {
+			return response, route, nil
+		}
+		lastErr = fmt.Errorf("%s api error: %w", route, err)
+		if started.Load() || !shouldFallback(ctx, err, i, len(chain)) {
+			break
+		}
+		log.Printf("[ WARN ] %v, trying the next model", lastErr)
+	}
+	return models.Completion{}, models.ModelRoute{}, lastErr
+}
+
+func shouldFallback(ctx context.Context, err error, attempt, routes int) bool {
+	if attempt >= routes-1 || ctx.Err() != nil {
+		return false
+	}
+	if models.IsRetryable(err) || errors.Is(err, models.ErrCircuitOpen) { // An open circuit means the provider is known to be down
+		return true
+	}
+	var netErr net.Error // An unreachable host is not worth repeating, but the next model may live on another one
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,199 @@
+// Tasks: Shared conversations of group chats and the settings group admins can change.
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"quokka-ai-bot/i18n"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+const groupPrompt = "You are talking in a group chat with several people. Each user message starts with the name of its author."
+
+// Dialog tells where a message was written. The author pays for the answer, the chat and the topic select the history.
+type Dialog struct {
+	UserID   int64 // Author of the message
+	ChatID   int64 // Chat of the message, equal to UserID in private chats
+	ThreadID int   // Forum topic with its own history, 0 otherwise
+}
+
+func (d Dialog) private() bool {
+	return d.ChatID == 0 || d.ChatID == d.UserID
+}
+
+func (d Dialog) owner() int64 { // Whose persona applies: the user in private chats, the whole group otherwise
+	if d.private() {
+		return d.UserID
+	}
+	return d.ChatID
+}
+
+// conversationOf returns the conversation of the dialog: the active one of the user in private chats
+// and the one shared by all members in groups.
+func (h *NeuralHandler) conversationOf(ctx context.Context, d Dialog) (int64, error) {
+	if d.private() {
+		return h.activeConversation(ctx, d.UserID)
+	}
+	return h.groupConversation(ctx, d.ChatID, d.ThreadID)
+}
+
+func (h *NeuralHandler) groupConversation(ctx context.Context, chatID int64, threadID int) (int64, error) { // Finds or creates the conversation of a group chat or its topic
+	var id int64
+	_, err := h.DB.ExecContext(ctx,
+		`INSERT INTO conversations (user_id, thread_id, created_at, updated_at) VALUES ($1, $2, $3, $3)
+		ON CONFLICT (user_id, thread_id) WHERE user_id < 0 DO NOTHING`,
+		chatID, threadID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	err = h.DB.QueryRowContext(ctx, "SELECT id FROM conversations WHERE user_id = $1 AND thread_id = $2", chatID, threadID).Scan(&id)
+	return id, err
+}
+
+type GroupSettings struct {
+	RespondAll bool // Answer every message, not only mentions, replies and /ask
+	PerThread  bool // Separate history for every forum topic
+}
+
+var defaultGroupSettings = GroupSettings{PerThread: true}
+
+// GroupSettings returns the settings of a group chat, the default ones if admins have not changed anything.
+func (h *NeuralHandler) GroupSettings(ctx context.Context, chatID int64) (GroupSettings, error) {
+	var s GroupSettings
+	err := h.DB.QueryRowContext(ctx, "SELECT respond_all, per_thread FROM group_settings WHERE chat_id = $1", chatID).Scan(&s.RespondAll, &s.PerThread)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultGroupSettings, nil
+	}
+	if err != nil {
+		return defaultGroupSettings, err
+	}
+	return s, nil
+}
+
+func (h *NeuralHandler) SetGroupSettings(ctx context.Context, chatID int64, s GroupSettings) error {
+	_, err := h.DB.ExecContext(ctx,
+		`INSERT INTO group_settings (chat_id, respond_all, per_thread, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (chat_id) DO UPDATE SET respond_all = EXCLUDED.respond_all, per_thread = EXCLUDED.per_thread, updated_at = EXCLUDED.updated_at`,
+		chatID, s.RespondAll, s.PerThread, time.Now())
+	return err
+}
+
+// dialogOf tells where the message of the context was written. Forum topics get their own history unless the group turned it off.
+func (h *TelegramHandler) dialogOf(ctx context.Context, c telebot.Context) Dialog {
+	d := Dialog{UserID: c.Sender().ID, ChatID: c.Chat().ID}
+	if msg := c.Message(); !d.private() && msg != nil && msg.TopicMessage {
+		settings, err := h.Neural.GroupSettings(ctx, d.ChatID)
+		if err != nil {
+			h.Logger.Printf("[ ERROR ] Group settings error for chat %d: %v", d.ChatID, err)
+		}
+		if settings.PerThread {
+			d.ThreadID = msg.ThreadID
+		}
+	}
+	return d
+}
+
+// groupRequest returns the text addressed to the bot in a group: a message mentioning it, a reply to it,
+// or any message if the group asked the bot to answer everything. ok is false if the message is not for the bot.
+func (h *TelegramHandler) groupRequest(c telebot.Context) (text string, ok bool) {
+	text = c.Message().Text
+	if h.mention != nil && h.mention.MatchString(text) {
+		text = h.mention.ReplaceAllString(text, "")
+	} else if !h.groupAddressed(c) {
+		return "", false
+	}
+	text = strings.TrimSpace(text)
+	return text, text != ""
+}
+
+func (h *TelegramHandler) groupAddressed(c telebot.Context) bool { // A group message without a mention is for the bot if it replies to the bot or the group asked to answer everything
+	msg := c.Message()
+	if msg.ReplyTo != nil && msg.ReplyTo.Sender != nil && msg.ReplyTo.Sender.ID == h.Bot.Me.ID {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	settings, err := h.Neural.GroupSettings(ctx, c.Chat().ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Group settings error for chat %d: %v", c.Chat().ID, err)
+	}
+	return settings.RespondAll
+}
+
+func (h *TelegramHandler) isGroupAdmin(c telebot.Context) bool { // Reports whether the sender may change the bot for the whole group
+	if msg := c.Message(); msg != nil && msg.SenderChat != nil && msg.SenderChat.ID == c.Chat().ID { // An anonymous admin writes on behalf of the group
+		return true
+	}
+	member, err := h.Bot.ChatMemberOf(c.Chat(), c.Sender())
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to get member %d of chat %d: %v", c.Sender().ID, c.Chat().ID, err)
+		return false
+	}
+	return member.Role == telebot.Creator || member.Role == telebot.Administrator
+}
+
+func replyOf(msg *telebot.Message) ReplyTo { // The message the user replied to, the first message of a forum topic is not a real reply
+	r := msg.ReplyTo
+	if r == nil || r.TopicCreated != nil {
+		return ReplyTo{}
+	}
+	reply := ReplyTo{MessageID: r.ID, Text: r.Text}
+	if reply.Text == "" {
+		reply.Text = r.Caption
+	}
+	if msg.Quote != nil {
+		reply.Quote = msg.Quote.Text
+	}
+	return reply
+}
+
+func authorName(user *telebot.User) string {
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if name == "" {
+		name = user.Username
+	}
+	return name
+}
+
+func (h *TelegramHandler) sendGroupSettings(c telebot.Context) error {
+	if c.Message().Private() {
+		return c.Send(h.text(c, "commands.groups_only"))
+	}
+	if !h.isGroupAdmin(c) {
+		return c.Send(h.text(c, "commands.admins_only"))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	settings, err := h.Neural.GroupSettings(ctx, c.Chat().ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Group settings error for chat %d: %v", c.Chat().ID, err)
+		return c.Send(h.text(c, "group.settings_failed"))
+	}
+	return c.Send(h.text(c, "group.settings"), groupSettingsMarkup(h.lang(c), settings), telebot.ModeHTML)
+}
+
+const ( // Data of the group settings buttons
+	groupRespondAll = "respond_all"
+	groupPerThread  = "per_thread"
+)
+
+func groupSettingsMarkup(lang string, settings GroupSettings) *telebot.ReplyMarkup {
+	markup := &telebot.ReplyMarkup{}
+	markup.Inline(
+		markup.Row(markup.Data(onOff(settings.RespondAll)+" "+i18n.T(lang, "group.respond_all"), groupSettingsButton, groupRespondAll)),
+		markup.Row(markup.Data(onOff(settings.PerThread)+" "+i18n.T(lang, "group.per_thread"), groupSettingsButton, groupPerThread)),
+	)
+	return markup
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "✅"
+	}
+	return "❌"
+}
@@ -0,0 +1,139 @@
+// Tasks: Answering photos through the vision models, the history keeps a description of the image instead of the image.
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"quokka-ai-bot/models"
+	"strings"
+)
+
+const (
+	imageQuestion         = "What is in this image?" // Asked when the photo has no caption
+	descriptionOpen       = "<image_description>"
+	descriptionClose      = "</image_description>"
+	describePrompt        = "After the answer, on a separate line, describe the image in detail, including any visible text, between " + descriptionOpen + " and " + descriptionClose + ", so that someone who cannot see it could answer questions about it. The user does not see this part."
+	imageDescriptionLimit = 2000 // Characters of the description stored in the history
+)
+
+var ErrVisionDisabled = errors.New("no vision model is configured")
+
+type Image struct {
+	MIME string
+	Data []byte
+}
+
+// HandleImageStream answers a photo with its caption through the vision models, passing the answer to onDelta while it is generated.
+// The image itself is not stored: the history gets its description, so later questions can refer to it. The model writes
+// the description after the answer in the same request, it is cut out of the text the user sees.
+func (h *NeuralHandler) HandleImageStream(ctx context.Context, d Dialog, img Image, caption string, reply ReplyTo, onDelta func(delta string)) (Answer, error) {
+	if len(h.Vision) == 0 {
+		return Answer{}, ErrVisionDisabled
+	}
+	conversationID, err := h.conversationOf(ctx, d)
+	if err != nil {
+		return Answer{}, fmt.Errorf("failed to get active conversation: %w", err)
+	}
+	request, err := h.buildRequest(ctx, d.owner(), conversationID, 0)
+	if err != nil {
+		return Answer{}, err
+	}
+	if !d.private() {
+		request.Messages[0].Content += "\n\n" + groupPrompt
+	}
+	request.Messages[0].Content += "\n\n" + describePrompt
+	ctx, _ = h.applyUserSettings(ctx, d.UserID, &request) // The chosen model is not used, images need the vision chain
+	question := caption
+	if question == "" {
+		question = imageQuestion
+	}
+	request.Messages = append(request.Messages, models.Message{Role: "user", Content: question})
+	if err := h.addReplyContext(ctx, d, reply, &request); err != nil {
+		return Answer{}, fmt.Errorf("failed to get replied message: %w", err)
+	}
+	last := &request.Messages[len(request.Messages)-1]
+	last.Parts = []models.ContentPart{models.TextPart(last.Content), models.ImagePart(img.MIME, img.Data)}
+	request.Messages = h.fitContext(request.Messages)
+
+	hidden := &descriptionFilter{onDelta: onDelta}
+	response, route, err := h.completeStreamOn(ctx, h.Vision, request, hidden.Write)
+	if err != nil {
+		return Answer{}, err
+	}
+	hidden.Flush()
+
+	var description string
+	response.Content, description = cutDescription(response.Content)
+	if description == "" {
+		log.Printf("[ WARN ] %s answered the image of %d without a description", route, d.UserID)
+		description = "no description available"
+	}
+	stored := fmt.Sprintf("[Image: %s]", description)
+	if caption != "" {
+		stored += "\n\n" + caption
+	}
+	if _, err := h.saveMessage(ctx, d.UserID, conversationID, "user", stored, ""); err != nil {
+		return Answer{}, fmt.Errorf("failed to save user message: %w", err)
+	}
+	id, err := h.saveAnswer(ctx, d.UserID, conversationID, response, route)
+	if err != nil {
+		return Answer{}, err
+	}
+	h.maintainInBackground(d.UserID, conversationID)
+
+	return Answer{MessageID: id, Text: response.Content, Reasoning: response.Reasoning}, nil
+}
+
+func cutDescription(content string) (answer, description string) { // Splits the answer from the description written after it
+	answer, description, found := strings.Cut(content, descriptionOpen)
+	if !found {
+		return strings.TrimSpace(content), ""
+	}
+	description, _, _ = strings.Cut(description, descriptionClose)
+	description, _ = truncateRunes(strings.TrimSpace(description), imageDescriptionLimit)
+	return strings.TrimSpace(answer), description
+}
+
+// descriptionFilter passes the streamed answer on and holds back everything from descriptionOpen on.
+// The end of a piece that may be the beginning of the tag waits for the next piece.
+type descriptionFilter struct {
+	onDelta func(delta string)
+	pending string
+	hidden  bool
+}
+
+func (f *descriptionFilter) Write(delta string) {
+	if f.hidden {
+		return
+	}
+	text := f.pending + delta
+	if i := strings.Index(text, descriptionOpen); i >= 0 {
+		f.hidden, f.pending = true, ""
+		f.emit(text[:i])
+		return
+	}
+	keep := 0
+	for k := min(len(descriptionOpen)-1, len(text)); k > 0; k-- {
+		if strings.HasSuffix(text, descriptionOpen[:k]) {
+			keep = k
+			break
+		}
+	}
+	f.pending = text[len(text)-keep:]
+	f.emit(text[:len(text)-keep])
+}
+
+func (f *descriptionFilter) Flush() { // Text held back at the end was not a tag after all
+	if !f.hidden {
+		f.emit(f.pending)
+		f.pending = ""
+	}
+}
+
+func (f *descriptionFilter) emit(text string) {
+	if text != "" && f.onDelta != nil {
+		f.onDelta(text)
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDescriptionFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		deltas []string
+		want   string
+	}{
+		{"no description", []string{"A cat ", "on a sofa."}, "A cat on a sofa."},
+		{"tag in one piece", []string{"A cat.\n", "<image_description>A grey cat</image_description>"}, "A cat.\n"},
+		{"tag split across pieces", []string{"A cat.\n<ima", "ge_descr", "iption>A grey cat", "</image_description>"}, "A cat.\n"},
+		{"held back text that is not a tag", []string{"Use <i", "mg> tags."}, "Use <img> tags."},
+		{"text ends with a tag prefix", []string{"a <", "image"}, "a <image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got strings.Builder
+			f := &descriptionFilter{onDelta: func(delta string) { got.WriteString(delta) }}
+			for _, delta := range tt.deltas {
+				f.Write(delta)
+			}
+			f.Flush()
+			if got.String() != tt.want {
+				t.Errorf("shown %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCutDescription(t *testing.T) {
+	tests := []struct {
+		content, answer, description string
+	}{
+		{"A cat.", "A cat.", ""},
+		{"A cat.\n\n<image_description> A grey cat. </image_description>\n", "A cat.", "A grey cat."},
+		{"A cat.\n<image_description>Cut off", "A cat.", "Cut off"},
+	}
+	for _, tt := range tests {
+		answer, description := cutDescription(tt.content)
+		if answer != tt.answer || description != tt.description {
+			t.Errorf("cutDescription(%q) = %q, %q, want %q, %q", tt.content, answer, description, tt.answer, tt.description)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Tasks: Answering inline queries (@bot question) from any chat, with a debounce and a cache of answers.
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"quokka-ai-bot/utils"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/telebot.v4"
+)
+
+const (
+	inlineDebounce = 800 * time.Millisecond // Telegram sends a query for every typed character, only the last one is answered
+	inlineWait     = 8 * time.Second        // How long a query waits for the model, Telegram drops queries that are answered too late
+	inlineCacheTTL = time.Hour
+)
+
+type inlineJob struct { // An answer that is being generated, several queries with the same text wait for it
+	done   chan struct{}
+	answer string
+	err    error
+}
+
+func normalizeQuery(text string) string { // Queries that differ only in case and spaces share a cached answer
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+func inlineCacheKey(query string, settings UserSettings) string { // Users whose settings shape answers differently do not share them
+	sum := sha256.Sum256([]byte(settings.answerStyle() + "\n" + query))
+	return "inline_answer:" + hex.EncodeToString(sum[:])
+}
+
+func (h *TelegramHandler) answerQuery(c telebot.Context) error {
+	user := c.Sender()
+	query := c.Query()
+	text := strings.TrimSpace(query.Text)
+	if text == "" {
+		return nil
+	}
+
+	h.inlineLatest.Store(user.ID, query.ID) // Debounce: wait a little and give up if the user has typed more since
+	time.Sleep(inlineDebounce)
+	if latest, _ := h.inlineLatest.Load(user.ID); latest != query.ID {
+		return nil
+	}
+	h.Logger.Printf("Inline query from %d %s: %.100s...", user.ID, user.Username, text)
+
+	ctx, cancel := context.WithTimeout(context.Background(), inlineWait)
+	defer cancel()
+
+	settings, err := h.Neural.UserSettings(ctx, user.ID) // AnswerOnce falls back to the defaults too, so the key matches the answer
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] User settings error for user %d %s: %v", user.ID, user.Username, err)
+	}
+	key := inlineCacheKey(normalizeQuery(text), settings)
+	if answer, ok := h.cachedInline(ctx, key); ok {
+		return h.sendInlineAnswer(c, text, answer)
+	}
+
+	job, running := h.inlineJobs.Load(key)
+	if !running { // Only a new generation is limited, waiting for a running one is free
+		allowed, waitTime, err := h.checkRateLimitMessage(user.ID)
+		if err != nil {
+			h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+		} else if !allowed {
+			h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+			return h.sendInlineNotice(c, h.text(c, "wait.inline", waitTime.Seconds()))
+		}
+		if reply, exceeded := h.checkQuota(ctx, h.lang(c), user); exceeded {
+			return h.sendInlineNotice(c, reply)
+		}
+		job, running = h.inlineJobs.LoadOrStore(key, &inlineJob{done: make(chan struct{})})
+		if !running {
+			go h.generateInline(key, user.ID, text, job.(*inlineJob))
+		}
+	}
+
+	select {
+	case <-job.(*inlineJob).done:
+	case <-ctx.Done():
+		return h.sendInlineNotice(c, h.text(c, "wait.inline_pending"))
+	}
+	if err := job.(*inlineJob).err; err != nil {
+		return h.sendInlineNotice(c, errorReply(h.lang(c), err))
+	}
+	return h.sendInlineAnswer(c, text, job.(*inlineJob).answer)
+}
+
+func (h *TelegramHandler) generateInline(key string, userID int64, text string, job *inlineJob) { // Runs detached from the query, so a slow answer still ends up in the cache
+	defer func() {
+		close(job.done)
+		h.inlineJobs.Delete(key)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	job.answer, job.err = h.Neural.AnswerOnce(ctx, userID, text)
+	if job.err != nil {
+		h.Logger.Printf("[ ERROR ] Error from Neural for inline query of %d: %v", userID, job.err)
+		return
+	}
+	if job.answer == "" {
+		job.err = errors.New("empty answer")
+		return
+	}
+	if err := h.cacheInline(ctx, key, job.answer); err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to cache inline answer: %v", err)
+	}
+}
+
+func (h *TelegramHandler) cachedInline(ctx context.Context, key string) (string, bool) {
+	cached, err := h.Redis.Get(ctx, key).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			h.Logger.Printf("[ ERROR ] Redis error while reading inline cache: %v", err)
+		}
+		return "", false
+	}
+	answer, err := utils.DecryptMessage(cached)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to decrypt inline answer: %v", err)
+		return "", false
+	}
+	return answer, true
+}
+
+func (h *TelegramHandler) cacheInline(ctx context.Context, key, answer string) error {
+	encrypted, err := utils.EncryptMessage(answer) // Answers are user content, stored like the history
+	if err != nil {
+		return err
+	}
+	return h.Redis.Set(ctx, key, encrypted, inlineCacheTTL).Err()
+}
+
+func (h *TelegramHandler) sendInlineAnswer(c telebot.Context, question, answer string) error {
+	chunks := splitRendered(answer, messageLimit-2)
+	text := chunks[0]
+	if len(chunks) > 1 { // An inline message is a single message, the rest can be asked in the bot chat
+		text += "\n…"
+	}
+	preview, _ := truncateRunes(strings.Join(strings.Fields(stripInline(text)), " "), 100)
+	content := &telebot.InputTextMessageContent{Text: renderMarkdown(text), ParseMode: telebot.ModeHTML}
+	result := &telebot.ArticleResult{Title: question, Description: preview}
+	result.Content = content
+	response := &telebot.QueryResponse{Results: telebot.Results{result}, CacheTime: int(inlineCacheTTL.Seconds()), IsPersonal: true} // The answer follows the settings of the user
+	err := c.Answer(response)
+	if isEntityError(err) {
+		content.Text, content.ParseMode = text, ""
+		err = c.Answer(response)
+	}
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to answer inline query of %d: %v", c.Sender().ID, err)
+	}
+	return err
+}
+
+func (h *TelegramHandler) sendInlineNotice(c telebot.Context, notice string) error { // Shows a notice above the results instead of an answer
+	err := c.Answer(&telebot.QueryResponse{
+		Results:    telebot.Results{},
+		IsPersonal: true,
+		Button:     &telebot.QueryResponseButton{Text: notice, Start: "inline"},
+	})
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to answer inline query of %d: %v", c.Sender().ID, err)
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+// Tasks: The interface language of every user: chosen with /language or taken from the Telegram client.
+package handlers
+
+import (
+	"context"
+	"quokka-ai-bot/i18n"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+const (
+	langKey      = "lang" // Context key of the language of the sender, it is looked up once per update
+	languageAuto = "auto" // Button data of following the Telegram client
+)
+
+// lang returns the interface language of the sender: the one chosen with /language,
+// otherwise the language of their Telegram client.
+func (h *TelegramHandler) lang(c telebot.Context) string {
+	if lang, ok := c.Get(langKey).(string); ok {
+		return lang
+	}
+	user := c.Sender()
+	lang := i18n.Match(user.LanguageCode)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	settings, err := h.Neural.UserSettings(ctx, user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] User settings error for user %d %s: %v", user.ID, user.Username, err)
+	} else if i18n.Supported(settings.Locale) {
+		lang = settings.Locale
+	}
+	c.Set(langKey, lang)
+	return lang
+}
+
+func (h *TelegramHandler) text(c telebot.Context, key string, args ...any) string { // The text of key in the language of the sender
+	return i18n.T(h.lang(c), key, args...)
+}
+
+func (h *TelegramHandler) sendLanguageMenu(c telebot.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	settings, err := h.Neural.UserSettings(ctx, c.Sender().ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] User settings error for user %d %s: %v", c.Sender().ID, c.Sender().Username, err)
+	}
+	return c.Send(h.text(c, "language.menu"), languageMarkup(h.lang(c), settings.Locale), telebot.ModeHTML)
+}
+
+func languageMarkup(lang, current string) *telebot.ReplyMarkup { // Following Telegram and every catalog in its own language, the chosen one is marked
+	markup := &telebot.ReplyMarkup{}
+	title := i18n.T(lang, "language.auto")
+	if current == "" {
+		title = "✅ " + title
+	}
+	rows := []telebot.Row{markup.Row(markup.Data(title, languageButton, languageAuto))}
+	for _, code := range i18n.Languages() {
+		title := i18n.T(code, "language.name")
+		if code == current {
+			title = "✅ " + title
+		}
+		rows = append(rows, markup.Row(markup.Data(title, languageButton, code)))
+	}
+	markup.Inline(rows...)
+	return markup
+}
+
+func (h *TelegramHandler) HandleLanguageCallback(c telebot.Context) error { // A language button was pressed
+	user := c.Sender()
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	locale := c.Callback().Data
+	if locale == languageAuto {
+		locale = ""
+	} else if !i18n.Supported(locale) {
+		return c.Respond(&telebot.CallbackResponse{Text: h.text(c, "settings.unavailable")})
+	}
+	settings, err := h.Neural.UserSettings(ctx, user.ID)
+	if err == nil {
+		settings.Locale = locale
+		err = h.Neural.SetUserSettings(ctx, user.ID, settings)
+	}
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] User settings error for user %d %s: %v", user.ID, user.Username, err)
+		return c.Respond(&telebot.CallbackResponse{Text: h.text(c, "language.failed")})
+	}
+	h.Logger.Printf("User %d %s switched language to %q", user.ID, user.Username, locale)
+	c.Set(langKey, nil) // The answer is already in the new language
+	if err := c.Respond(&telebot.CallbackResponse{Text: h.text(c, "language.saved")}); err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to answer callback %d: %v", user.ID, err)
+	}
+	return c.Edit(h.text(c, "language.menu"), languageMarkup(h.lang(c), settings.Locale), telebot.ModeHTML)
+}
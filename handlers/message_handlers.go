@@ -1,27 +1,57 @@
 package handlers
 
 import (
-	"fmt"
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/telebot.v4"
 )
 
 func (h *TelegramHandler) HandleText(c telebot.Context) error {
+	text := c.Text()
+	if !c.Message().Private() {
+		var ok bool
+		if text, ok = h.groupRequest(c); !ok { // In groups the bot answers only when it is addressed
+			return nil
+		}
+	}
+	return h.handleRequest(c, text)
+}
+
+func (h *TelegramHandler) HandleAsk(c telebot.Context) error { // Asks the question from the payload, or the text of the replied message
+	text := strings.TrimSpace(c.Message().Payload)
+	if text == "" && c.Message().ReplyTo != nil {
+		text = strings.TrimSpace(c.Message().ReplyTo.Text)
+	}
+	if text == "" {
+		return c.Send(h.text(c, "commands.ask_usage"))
+	}
+	return h.handleRequest(c, text)
+}
+
+func (h *TelegramHandler) HandleQuery(c telebot.Context) error { // Inline mode: @bot question in any chat
+	return h.answerQuery(c)
+}
+
+func (h *TelegramHandler) handleRequest(c telebot.Context, text string) error { // Checks the rate limit and answers the text
 	user := c.Sender()
 
 	allowed, waitTime, err := h.checkRateLimitMessage(user.ID)
 	if err != nil {
 		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
 		// In case of a Redis error, we skip the check so as not to block users
-		return h.processMessage(c)
+		return h.processMessage(c, text)
 	}
 
 	if !allowed {
 		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
-		return c.Send(fmt.Sprintf("⏳ Пожалуйста, подождите %.0f секунд перед следующим запросом", waitTime.Seconds()))
+		return c.Send(h.text(c, "wait.request", waitTime.Seconds()))
 	}
 
-	return h.processMessage(c)
+	return h.processMessage(c, text)
 }
 
 func (h *TelegramHandler) HandleStart(c telebot.Context) error {
@@ -31,15 +61,15 @@ func (h *TelegramHandler) HandleStart(c telebot.Context) error {
 	if err != nil {
 		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
 		// In case of a Redis error, we skip the check so as not to block users
-		return c.Send(h.messageStart(), telebot.ModeHTML)
+		return c.Send(h.messageStart(h.lang(c)), telebot.ModeHTML)
 	}
 
 	if !allowed {
 		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
-		return c.Send(fmt.Sprintf("⏳ Пожалуйста, подождите %.0f секунд перед следующей командой", waitTime.Seconds()))
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
 	}
 
-	return c.Send(h.messageStart(), telebot.ModeHTML)
+	return c.Send(h.messageStart(h.lang(c)), telebot.ModeHTML)
 }
 
 func (h *TelegramHandler) HandleReset(c telebot.Context) error { // Clearing history
@@ -48,13 +78,13 @@ func (h *TelegramHandler) HandleReset(c telebot.Context) error { // Clearing his
 	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
 	if err != nil {
 		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
-		return c.Send(h.messageReset(user))
+		return c.Send(h.messageReset(c))
 	}
 	if !allowed {
 		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
-		return c.Send(fmt.Sprintf("⏳ Пожалуйста, подождите %.0f секунд перед следующей командой", waitTime.Seconds()))
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
 	}
-	return c.Send(h.messageReset(user))
+	return c.Send(h.messageReset(c))
 }
 
 func (h *TelegramHandler) HandleHelp(c telebot.Context) error {
@@ -63,13 +93,13 @@ func (h *TelegramHandler) HandleHelp(c telebot.Context) error {
 	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
 	if err != nil {
 		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
-		c.Send(h.messageHelp(), telebot.ModeHTML)
+		c.Send(h.messageHelp(h.lang(c)), telebot.ModeHTML)
 	}
 	if !allowed {
 		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
-		return c.Send(fmt.Sprintf("⏳ Пожалуйста, подождите %.0f секунд перед следующей командой", waitTime.Seconds()))
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
 	}
-	return c.Send(h.messageHelp(), telebot.ModeHTML)
+	return c.Send(h.messageHelp(h.lang(c)), telebot.ModeHTML)
 }
 
 func (h *TelegramHandler) HandleAbout(c telebot.Context) error {
@@ -78,13 +108,13 @@ func (h *TelegramHandler) HandleAbout(c telebot.Context) error {
 	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
 	if err != nil {
 		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
-		return c.Send(h.messageAbout(), telebot.ModeHTML)
+		return c.Send(h.messageAbout(h.lang(c)), telebot.ModeHTML)
 	}
 	if !allowed {
 		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
-		return c.Send(fmt.Sprintf("⏳ Пожалуйста, подождите %.0f секунд перед следующей командой", waitTime.Seconds()))
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
 	}
-	return c.Send(h.messageAbout(), telebot.ModeHTML)
+	return c.Send(h.messageAbout(h.lang(c)), telebot.ModeHTML)
 }
 
 func (h *TelegramHandler) HandlePolicy(c telebot.Context) error {
@@ -93,13 +123,13 @@ func (h *TelegramHandler) HandlePolicy(c telebot.Context) error {
 	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
 	if err != nil {
 		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
-		return c.Send(h.messagePolicy(), telebot.ModeHTML)
+		return c.Send(h.messagePolicy(h.lang(c)), telebot.ModeHTML)
 	}
 	if !allowed {
 		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
-		return c.Send(fmt.Sprintf("⏳ Пожалуйста, подождите %.0f секунд перед следующей командой", waitTime.Seconds()))
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
 	}
-	return c.Send(h.messagePolicy(), telebot.ModeHTML)
+	return c.Send(h.messagePolicy(h.lang(c)), telebot.ModeHTML)
 }
 
 func (h *TelegramHandler) HandleRules(c telebot.Context) error {
@@ -108,11 +138,390 @@ func (h *TelegramHandler) HandleRules(c telebot.Context) error {
 	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
 	if err != nil {
 		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
-		return c.Send(h.messageRules(), telebot.ModeHTML)
+		return c.Send(h.messageRules(h.lang(c)), telebot.ModeHTML)
+	}
+	if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
+	}
+	return c.Send(h.messageRules(h.lang(c)), telebot.ModeHTML)
+}
+
+func (h *TelegramHandler) HandleLimits(c telebot.Context) error {
+	user := c.Sender()
+	h.Logger.Printf("Limits message from user %d %s", user.ID, user.Username)
+	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+		return c.Send(h.messageLimits(h.lang(c), user), telebot.ModeHTML)
+	}
+	if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
+	}
+	return c.Send(h.messageLimits(h.lang(c), user), telebot.ModeHTML)
+}
+
+func (h *TelegramHandler) HandleReasoning(c telebot.Context) error { // Switches showing the thinking of reasoning models
+	user := c.Sender()
+	h.Logger.Printf("Reasoning message from user %d %s", user.ID, user.Username)
+	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+		return c.Send(h.messageReasoning(h.lang(c), user), telebot.ModeHTML)
+	}
+	if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
+	}
+	return c.Send(h.messageReasoning(h.lang(c), user), telebot.ModeHTML)
+}
+
+func (h *TelegramHandler) HandleSettings(c telebot.Context) error {
+	user := c.Sender()
+	h.Logger.Printf("Settings message from user %d %s", user.ID, user.Username)
+	if !c.Message().Private() { // The menu belongs to one user, in a group anyone could press its buttons
+		return c.Send(h.text(c, "commands.private_only"))
+	}
+	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+		return h.sendSettings(c)
+	}
+	if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
+	}
+	return h.sendSettings(c)
+}
+
+// HandleSettingsCallback handles the buttons of /settings: a setting opens the list of its options,
+// an option is saved and leads back to the settings, the reasoning button switches at once.
+func (h *TelegramHandler) HandleSettingsCallback(c telebot.Context) error {
+	user := c.Sender()
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	settings, err := h.Neural.UserSettings(ctx, user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] User settings error for user %d %s: %v", user.ID, user.Username, err)
+		return c.Respond(&telebot.CallbackResponse{Text: h.text(c, "settings.get_failed")})
+	}
+	setting, value, chosen := strings.Cut(c.Callback().Data, "|")
+	switch {
+	case setting == settingsMenu:
+		if err := c.Respond(); err != nil {
+			h.Logger.Printf("[ ERROR ] Failed to answer callback %d: %v", user.ID, err)
+		}
+	case setting == settingsReasoning || chosen:
+		if setting == settingsReasoning {
+			settings.ShowReasoning = !settings.ShowReasoning
+		} else {
+			options := h.Neural.settingOptions(setting)
+			i, err := strconv.Atoi(value)
+			if err != nil || i < 0 || i >= len(options) { // The list of models changed since the menu was sent
+				return c.Respond(&telebot.CallbackResponse{Text: h.text(c, "settings.unavailable")})
+			}
+			settings.set(setting, options[i].ID)
+		}
+		if err := h.Neural.SetUserSettings(ctx, user.ID, settings); err != nil {
+			h.Logger.Printf("[ ERROR ] User settings error for user %d %s: %v", user.ID, user.Username, err)
+			return c.Respond(&telebot.CallbackResponse{Text: h.text(c, "settings.save_failed")})
+		}
+		h.Logger.Printf("User %d %s changed settings: %+v", user.ID, user.Username, settings)
+		if err := c.Respond(&telebot.CallbackResponse{Text: h.text(c, "settings.saved")}); err != nil {
+			h.Logger.Printf("[ ERROR ] Failed to answer callback %d: %v", user.ID, err)
+		}
+	default:
+		if !slices.Contains(settingsOrder, setting) {
+			return c.Respond()
+		}
+		if err := c.Respond(); err != nil {
+			h.Logger.Printf("[ ERROR ] Failed to answer callback %d: %v", user.ID, err)
+		}
+		return c.Edit(messageSettingOptions(h.lang(c), setting), h.settingOptionsMarkup(h.lang(c), setting, settings.get(setting)), telebot.ModeHTML)
+	}
+	return c.Edit(h.messageSettings(h.lang(c), settings), settingsMarkup(h.lang(c), settings), telebot.ModeHTML)
+}
+
+func (h *TelegramHandler) HandleLanguage(c telebot.Context) error {
+	user := c.Sender()
+	h.Logger.Printf("Language message from user %d %s", user.ID, user.Username)
+	if !c.Message().Private() { // The menu belongs to one user, in a group anyone could press its buttons
+		return c.Send(h.text(c, "commands.private_only"))
+	}
+	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+		return h.sendLanguageMenu(c)
 	}
 	if !allowed {
 		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
-		return c.Send(fmt.Sprintf("⏳ Пожалуйста, подождите %.0f секунд перед следующей командой", waitTime.Seconds()))
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
+	}
+	return h.sendLanguageMenu(c)
+}
+
+func (h *TelegramHandler) HandleTimezone(c telebot.Context) error { // Sets the time zone of the user: /timezone Europe/Moscow
+	user := c.Sender()
+	h.Logger.Printf("Timezone message from user %d %s", user.ID, user.Username)
+	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+		return c.Send(h.messageTimezone(h.lang(c), user, c.Message().Payload), telebot.ModeHTML)
+	}
+	if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
+	}
+	return c.Send(h.messageTimezone(h.lang(c), user, c.Message().Payload), telebot.ModeHTML)
+}
+
+func (h *TelegramHandler) HandlePersona(c telebot.Context) error {
+	user := c.Sender()
+	h.Logger.Printf("Persona message from user %d %s", user.ID, user.Username)
+	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+		return h.sendPersonaMenu(c)
+	}
+	if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
+	}
+	return h.sendPersonaMenu(c)
+}
+
+func (h *TelegramHandler) HandlePersonaCallback(c telebot.Context) error { // A persona button was pressed
+	user := c.Sender()
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	if c.Chat().Type != telebot.ChatPrivate && !h.isGroupAdmin(c) {
+		return c.Respond(&telebot.CallbackResponse{Text: h.text(c, "commands.admins_only"), ShowAlert: true})
+	}
+	persona, err := h.Neural.SetPersona(ctx, personaOwner(c), c.Callback().Data)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Persona error for user %d %s: %v", user.ID, user.Username, err)
+		return c.Respond(&telebot.CallbackResponse{Text: h.text(c, "persona.failed")})
+	}
+	h.Logger.Printf("User %d %s switched persona to %s", user.ID, user.Username, persona.ID)
+	if err := c.Respond(&telebot.CallbackResponse{Text: h.text(c, "persona.switched", persona.Title(h.lang(c)))}); err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to answer callback %d: %v", user.ID, err)
+	}
+	return c.Edit(messagePersona(h.lang(c), persona), personaMarkup(h.lang(c), persona.ID), telebot.ModeHTML)
+}
+
+func (h *TelegramHandler) HandleNew(c telebot.Context) error { // Starts a new conversation, the payload is an optional title
+	user := c.Sender()
+	h.Logger.Printf("New message from user %d %s", user.ID, user.Username)
+	if !c.Message().Private() { // Groups have one shared conversation
+		return c.Send(h.text(c, "commands.private_only"))
+	}
+	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+		return c.Send(h.messageNew(h.lang(c), user, c.Message().Payload), telebot.ModeHTML)
+	}
+	if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
+	}
+	return c.Send(h.messageNew(h.lang(c), user, c.Message().Payload), telebot.ModeHTML)
+}
+
+func (h *TelegramHandler) HandleChats(c telebot.Context) error {
+	user := c.Sender()
+	h.Logger.Printf("Chats message from user %d %s", user.ID, user.Username)
+	if !c.Message().Private() { // Groups have one shared conversation
+		return c.Send(h.text(c, "commands.private_only"))
+	}
+	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+		return h.sendChats(c)
+	}
+	if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
+	}
+	return h.sendChats(c)
+}
+
+func (h *TelegramHandler) HandleSwitch(c telebot.Context) error { // Switches to the conversation with the number from the /chats list
+	user := c.Sender()
+	h.Logger.Printf("Switch message from user %d %s", user.ID, user.Username)
+	if !c.Message().Private() { // Groups have one shared conversation
+		return c.Send(h.text(c, "commands.private_only"))
+	}
+	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+		return c.Send(h.messageSwitch(h.lang(c), user, c.Message().Payload), telebot.ModeHTML)
+	}
+	if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
+	}
+	return c.Send(h.messageSwitch(h.lang(c), user, c.Message().Payload), telebot.ModeHTML)
+}
+
+func (h *TelegramHandler) HandleChatCallback(c telebot.Context) error { // A conversation button of the /chats list was pressed
+	user := c.Sender()
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	conversationID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err == nil {
+		err = h.Neural.SwitchConversation(ctx, user.ID, conversationID)
+	}
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Switch error for user %d %s: %v", user.ID, user.Username, err)
+		return c.Respond(&telebot.CallbackResponse{Text: h.text(c, "chats.switch_failed")})
+	}
+	h.Logger.Printf("User %d %s switched to conversation %d", user.ID, user.Username, conversationID)
+	if err := c.Respond(&telebot.CallbackResponse{Text: h.text(c, "chats.switched")}); err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to answer callback %d: %v", user.ID, err)
+	}
+	text, markup := h.messageChats(h.lang(c), user)
+	return c.Edit(text, markup, telebot.ModeHTML)
+}
+
+func (h *TelegramHandler) HandleGroupSettings(c telebot.Context) error {
+	user := c.Sender()
+	h.Logger.Printf("Group settings message from user %d %s in %d", user.ID, user.Username, c.Chat().ID)
+	allowed, waitTime, err := h.checkRateLimitCommand(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+		return h.sendGroupSettings(c)
+	}
+	if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.command", waitTime.Seconds()))
+	}
+	return h.sendGroupSettings(c)
+}
+
+func (h *TelegramHandler) HandleGroupSettingsCallback(c telebot.Context) error { // A button of /groupsettings was pressed, it switches one setting
+	user := c.Sender()
+	if !h.isGroupAdmin(c) {
+		return c.Respond(&telebot.CallbackResponse{Text: h.text(c, "commands.admins_only"), ShowAlert: true})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	settings, err := h.Neural.GroupSettings(ctx, c.Chat().ID)
+	if err == nil {
+		switch c.Callback().Data {
+		case groupRespondAll:
+			settings.RespondAll = !settings.RespondAll
+		case groupPerThread:
+			settings.PerThread = !settings.PerThread
+		}
+		err = h.Neural.SetGroupSettings(ctx, c.Chat().ID, settings)
+	}
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Group settings error for chat %d: %v", c.Chat().ID, err)
+		return c.Respond(&telebot.CallbackResponse{Text: h.text(c, "settings.save_failed")})
+	}
+	h.Logger.Printf("User %d %s changed settings of chat %d: %+v", user.ID, user.Username, c.Chat().ID, settings)
+	if err := c.Respond(&telebot.CallbackResponse{Text: h.text(c, "settings.saved")}); err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to answer callback %d: %v", user.ID, err)
+	}
+	return c.Edit(h.text(c, "group.settings"), groupSettingsMarkup(h.lang(c), settings), telebot.ModeHTML)
+}
+
+// HandleRegenerateCallback handles the "Regenerate" button of an answer. A long answer is shown in several messages, the button
+// is under the last one: the new version is written into the first message and the other parts are removed once it is ready.
+func (h *TelegramHandler) HandleRegenerateCallback(c telebot.Context) error {
+	return h.handleAnswerCallback(c, "Regenerate", func(ctx context.Context, messageID int64) (*telebot.Message, func(func(string)) (Answer, error)) {
+		parts, err := h.Neural.LinkedParts(ctx, c.Chat().ID, messageID)
+		if err != nil {
+			h.Logger.Printf("[ ERROR ] Failed to get parts of answer %d for %d: %v", messageID, c.Sender().ID, err)
+		}
+		target := c.Message()
+		if len(parts) > 0 && parts[0] != target.ID {
+			target = &telebot.Message{ID: parts[0], Chat: c.Chat()}
+		}
+		return target, func(onDelta func(string)) (Answer, error) {
+			answer, err := h.Neural.Regenerate(ctx, c.Sender().ID, messageID, onDelta)
+			if err == nil {
+				h.removeParts(ctx, c, parts, target.ID)
+			}
+			return answer, err
+		}
+	})
+}
+
+// removeParts deletes the messages of an old answer except keep, so the new version is not shown below stale text.
+// Messages Telegram no longer lets delete are replaced with a note. Either way they stop referring to the answer.
+func (h *TelegramHandler) removeParts(ctx context.Context, c telebot.Context, parts []int, keep int) {
+	var removed []int
+	for _, id := range parts {
+		if id == keep {
+			continue
+		}
+		msg := &telebot.Message{ID: id, Chat: c.Chat()}
+		if err := c.Bot().Delete(msg); err != nil {
+			if err := safeEdit(c.Bot(), msg, h.text(c, "answer.replaced")); err != nil {
+				h.Logger.Printf("[ ERROR ] Failed to remove old answer part %d for %d: %v", id, c.Sender().ID, err)
+			}
+		}
+		removed = append(removed, id)
+	}
+	if err := h.Neural.UnlinkMessages(ctx, c.Chat().ID, removed); err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to unlink old answer parts for %d: %v", c.Sender().ID, err)
+	}
+}
+
+func (h *TelegramHandler) HandleContinueCallback(c telebot.Context) error { // The "Continue" button of an answer was pressed
+	return h.handleAnswerCallback(c, "Continue", func(ctx context.Context, messageID int64) (*telebot.Message, func(func(string)) (Answer, error)) {
+		if _, err := c.Bot().EditReplyMarkup(c.Message(), nil); err != nil { // The buttons move to the continuation
+			h.Logger.Printf("[ ERROR ] Failed to remove buttons for %d: %v", c.Sender().ID, err)
+		}
+		placeholder, err := c.Bot().Send(c.Recipient(), h.text(c, "answer.continuing"))
+		if err != nil {
+			h.Logger.Printf("[ ERROR ] Failed to send placeholder to %d: %v", c.Sender().ID, err)
+			return nil, nil
+		}
+		return placeholder, func(onDelta func(string)) (Answer, error) {
+			return h.Neural.Continue(ctx, c.Sender().ID, messageID, onDelta)
+		}
+	})
+}
+
+// handleAnswerCallback applies the same limits as text messages to an answer button and streams the new text
+// into the message chosen by prepare.
+func (h *TelegramHandler) handleAnswerCallback(c telebot.Context, action string, prepare func(ctx context.Context, messageID int64) (*telebot.Message, func(func(string)) (Answer, error))) error {
+	user := c.Sender()
+	h.Logger.Printf("%s callback from user %d %s", action, user.ID, user.Username)
+	messageID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&telebot.CallbackResponse{Text: h.text(c, "answer.bad_button")})
+	}
+
+	allowed, waitTime, err := h.checkRateLimitMessage(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+	} else if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Respond(&telebot.CallbackResponse{Text: h.text(c, "wait.request", waitTime.Seconds()), ShowAlert: true})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	if reply, exceeded := h.checkQuota(ctx, h.lang(c), user); exceeded {
+		return c.Respond(&telebot.CallbackResponse{Text: reply, ShowAlert: true})
+	}
+	if err := c.Respond(); err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to answer callback %d: %v", user.ID, err)
+	}
+
+	msg, generate := prepare(ctx, messageID)
+	if msg == nil {
+		return c.Send(h.text(c, "answer.send_failed"))
 	}
-	return c.Send(h.messageRules(), telebot.ModeHTML)
+	_, err = h.streamAnswer(c, msg, generate)
+	return err
 }
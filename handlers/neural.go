@@ -5,101 +5,329 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"log"
 	"quokka-ai-bot/config"
 	"quokka-ai-bot/models"
+	"quokka-ai-bot/tools"
 	"quokka-ai-bot/utils"
+	"sync"
 	"time"
 )
 
 type NeuralHandler struct {
-	DeepSeekClient *models.DeepSeekClient // Client for interacting with DeepSeek API
-	DB             *sql.DB                // Connecting to a database
+	Chain             []models.ModelRoute   // Models for interacting with the neural network API, tried in order
+	Tools             *tools.Registry       // Tools the model may call, nil disables them
+	MaxToolIterations int                   // Rounds of tool calls before the model has to answer
+	Location          *time.Location        // Time zone of users who have not set their own
+	Vision            []models.ModelRoute   // Models that answer images, tried in order. Empty if images are not supported
+	AttemptTimeout    time.Duration         // How long a streaming model may stay silent before the next one is tried
+	Quota             config.QuotaConfig    // Token limits of users
+	SystemPrompt      string                // Operator instructions, the persona of the user is appended to them
+	Estimator         models.TokenEstimator // Estimates message sizes, a heuristic by default
+	ContextTokens     int                   // Token budget of the request messages
+	HistoryLimit      int                   // Maximum number of stored messages loaded for the history
+	SummaryAfter      int                   // Unsummarized messages that trigger a new summary, negative disables summaries
+	SummaryKeep       int                   // Newest messages left out of the summary
+	AttachmentTokens  int                   // Token budget of the files attached to a conversation
+	maintaining       sync.Map              // IDs of conversations that are being summarized or titled right now
+	DB                *sql.DB               // Connecting to a database
 }
 
-func NewNeuralHandler(apiKey string, db *sql.DB) *NeuralHandler { // A constructor that creates a new instance of the handler
+func NewNeuralHandler(cfg *config.Config, chain, vision []models.ModelRoute, db *sql.DB) *NeuralHandler { // A constructor that creates a new instance of the handler
+	systemPrompt := cfg.SystemPrompt
+	if systemPrompt == "" {
+		systemPrompt = defaultSystemPrompt
+	}
+	location, err := time.LoadLocation(cfg.Tools.Timezone)
+	if err != nil {
+		log.Printf("[ WARN ] Unknown time zone %q, UTC is used: %v", cfg.Tools.Timezone, err)
+		location = time.UTC
+	}
+	var registry *tools.Registry
+	if cfg.Tools.IsEnabled() {
+		registry = tools.Default(location)
+	}
 	return &NeuralHandler{
-		DeepSeekClient: models.NewDeepSeekClient(apiKey),
-		DB:             db,
+		Chain:             chain,
+		Tools:             registry,
+		MaxToolIterations: cfg.Tools.Iterations(),
+		Location:          location,
+		Vision:            vision,
+		AttemptTimeout:    time.Duration(cfg.AttemptTimeout) * time.Second,
+		Quota:             cfg.Quota,
+		SystemPrompt:      systemPrompt,
+		Estimator:         models.HeuristicEstimator{},
+		ContextTokens:     cfg.ContextTokens,
+		HistoryLimit:      cfg.HistoryLimit,
+		SummaryAfter:      cfg.SummaryAfter,
+		SummaryKeep:       cfg.SummaryKeep,
+		AttachmentTokens:  cfg.AttachmentTokens,
+		DB:                db,
 	}
 }
 
-func (h *NeuralHandler) HandleMessage(ctx context.Context, userID int64, text string) (string, error) { // The main method of message processing
-	err := h.saveMessage(ctx, userID, "user", text) // Saves the user's message to the database. This is necessary so that the deepsik can further understand the context of the conversation.
+type Answer struct { // A saved answer of the neural network
+	MessageID int64 // Row of the assistant message in chat_messages
+	Text      string
+	Reasoning string // Thinking of a reasoning model, shown on request but never stored
+}
+
+func (h *NeuralHandler) HandleMessage(ctx context.Context, d Dialog, text string, reply ReplyTo) (Answer, error) { // The main method of message processing
+	conversationID, err := h.conversationOf(ctx, d)
 	if err != nil {
-		return "", fmt.Errorf("failed to save user message: %w", err)
+		return Answer{}, fmt.Errorf("failed to get active conversation: %w", err)
 	}
+	request, err := h.prepareRequest(ctx, d, conversationID, text, reply)
+	if err != nil {
+		return Answer{}, err
+	}
+	ctx, chain := h.applyUserSettings(ctx, d.UserID, &request) // The preferences of the one who asks, in groups too
 
-	messages, err := h.getMessages(ctx, userID, 10)
+	response, route, err := h.completeWithTools(ctx, request, h.completeWith(chain)) // Sends a request, running the tools the model asks for
 	if err != nil {
-		return "", fmt.Errorf("failed to get conversation history: %w", err)
+		return Answer{}, err
 	}
 
-	request := models.DeepSeekRequest{ // Generates a request to the DeepSeek API with the message history
-		Model:    config.Load().DeepSeekModel,
-		Messages: messages,
+	id, err := h.saveAnswer(ctx, d.UserID, conversationID, response, route) // We save the answer in the database. It is necessary for understanding the context of the conversation.
+	if err != nil {
+		return Answer{}, err
 	}
+	h.maintainInBackground(d.UserID, conversationID)
+
+	return Answer{MessageID: id, Text: response.Content, Reasoning: response.Reasoning}, nil
+}
 
-	response, err := h.DeepSeekClient.ChatCompletion(ctx, request) // Sends a request
+// HandleMessageStream works like HandleMessage, but passes the answer to onDelta piece by piece while it is being generated.
+// The answer is saved only after the stream has completed successfully.
+func (h *NeuralHandler) HandleMessageStream(ctx context.Context, d Dialog, text string, reply ReplyTo, onDelta func(delta string)) (Answer, error) {
+	conversationID, err := h.conversationOf(ctx, d)
+	if err != nil {
+		return Answer{}, fmt.Errorf("failed to get active conversation: %w", err)
+	}
+	request, err := h.prepareRequest(ctx, d, conversationID, text, reply)
 	if err != nil {
-		return "", fmt.Errorf("deepseek api error: %w", err)
+		return Answer{}, err
 	}
+	ctx, chain := h.applyUserSettings(ctx, d.UserID, &request)
 
-	err = h.saveMessage(ctx, userID, "assistant", response) // We save the answer in the database. It is necessary for understanding the context of the conversation.
+	response, route, err := h.completeWithTools(ctx, request, h.streamTo(chain, onDelta))
 	if err != nil {
-		return "", fmt.Errorf("failed to save assistant message: %w", err)
+		return Answer{}, err
 	}
 
-	return response, nil
+	id, err := h.saveAnswer(ctx, d.UserID, conversationID, response, route)
+	if err != nil {
+		return Answer{}, err
+	}
+	h.maintainInBackground(d.UserID, conversationID)
+
+	return Answer{MessageID: id, Text: response.Content, Reasoning: response.Reasoning}, nil
 }
 
-func (h *NeuralHandler) ResetConversation(ctx context.Context, userID int64) error { // Deletes all message history for the specified user
-	_, err := h.DB.ExecContext(ctx, "DELETE FROM chat_messages WHERE user_id = $1", userID)
-	return err
+// AnswerOnce answers a single question without a conversation and the persona of the user, as inline mode needs it.
+// Only the spent tokens are stored.
+func (h *NeuralHandler) AnswerOnce(ctx context.Context, userID int64, text string) (string, error) {
+	request := models.ChatRequest{
+		Messages: []models.Message{h.systemMessage(personas[0]), {Role: "user", Content: text}},
+	}
+	ctx, chain := h.applyUserSettings(ctx, userID, &request)
+	response, route, err := h.completeWithTools(ctx, request, h.completeWith(chain))
+	if err != nil {
+		return "", err
+	}
+	if err := h.saveUsage(ctx, userID, 0, route.String(), response.Usage); err != nil {
+		return "", fmt.Errorf("failed to save token usage: %w", err)
+	}
+	return response.Content, nil
 }
 
-func (h *NeuralHandler) saveMessage(ctx context.Context, userID int64, role, content string) error { // Saving a message to the database
-	aesContent, err := utils.EncryptMessage(content)
+func (h *NeuralHandler) prepareRequest(ctx context.Context, d Dialog, conversationID int64, text string, reply ReplyTo) (models.ChatRequest, error) { // Saves the user's message and builds a request with the conversation history
+	_, err := h.saveMessage(ctx, d.UserID, conversationID, "user", text, "") // Saves the user's message to the database. This is necessary so that the deepsik can further understand the context of the conversation.
+	if err != nil {
+		return models.ChatRequest{}, fmt.Errorf("failed to save user message: %w", err)
+	}
+	request, err := h.buildRequest(ctx, d.owner(), conversationID, 0) // Groups have one persona for all members
+	if err != nil {
+		return models.ChatRequest{}, err
+	}
+	if !d.private() {
+		request.Messages[0].Content += "\n\n" + groupPrompt
+	}
+	if err := h.addReplyContext(ctx, d, reply, &request); err != nil {
+		return models.ChatRequest{}, fmt.Errorf("failed to get replied message: %w", err)
+	}
+	return request, nil
+}
+
+// buildRequest collects the system prompt, the attached files, the summary and the history of the conversation into a request.
+// The message with the ID skipID is left out, which lets an answer be regenerated without its previous version.
+func (h *NeuralHandler) buildRequest(ctx context.Context, userID, conversationID, skipID int64) (models.ChatRequest, error) {
+	persona, err := h.Persona(ctx, userID)
+	if err != nil {
+		return models.ChatRequest{}, fmt.Errorf("failed to get persona: %w", err)
+	}
+	summary, err := h.getSummary(ctx, conversationID) // Older turns are represented by their summary
+	if err != nil {
+		return models.ChatRequest{}, fmt.Errorf("failed to get conversation summary: %w", err)
+	}
+	history, err := h.getMessages(ctx, conversationID, summary.LastMessageID, h.HistoryLimit)
+	if err != nil {
+		return models.ChatRequest{}, fmt.Errorf("failed to get conversation history: %w", err)
+	}
+	attachments, err := h.attachmentMessages(ctx, conversationID)
+	if err != nil {
+		return models.ChatRequest{}, fmt.Errorf("failed to get attachments: %w", err)
+	}
+
+	messages := []models.Message{h.systemMessage(persona)}
+	messages = append(messages, attachments...) // Files stay in the request as long as the conversation, like the system prompt
+	if summary.Content != "" {
+		messages = append(messages, summary.message())
+	}
+	for _, msg := range history {
+		if msg.ID != skipID {
+			messages = append(messages, msg.Message)
+		}
+	}
+
+	return models.ChatRequest{ // Generates a request with the message history, the provider fills in its model
+		Messages: h.fitContext(messages),
+	}, nil
+}
+
+// fitContext drops the oldest turns until the messages fit into the token budget.
+// Leading system messages and the newest message are always kept, even if they alone exceed the budget.
+func (h *NeuralHandler) fitContext(messages []models.Message) []models.Message {
+	pinned := 0
+	for pinned < len(messages) && messages[pinned].Role == "system" {
+		pinned++
+	}
+	if len(messages)-pinned < 2 {
+		return messages
+	}
+	last := len(messages) - 1
+	budget := h.ContextTokens - models.EstimateMessages(h.Estimator, messages[:pinned]) - models.EstimateMessages(h.Estimator, messages[last:])
+
+	start := last // Index of the oldest history message that fits
+	for start > pinned {
+		size := models.EstimateMessages(h.Estimator, messages[start-1:start])
+		if size > budget {
+			break
+		}
+		budget -= size
+		start--
+	}
+	for start < last && messages[start].Role != "user" { // The history should start with a question, not with a dangling answer
+		start++
+	}
+
+	fitted := make([]models.Message, 0, pinned+len(messages)-start)
+	fitted = append(fitted, messages[:pinned]...)
+	return append(fitted, messages[start:]...)
+}
+
+func (h *NeuralHandler) ResetConversation(ctx context.Context, d Dialog) error { // Deletes the message history and the files of the current conversation of the dialog
+	conversationID, err := h.conversationOf(ctx, d)
 	if err != nil {
 		return err
 	}
-	_, err = h.DB.ExecContext(ctx,
-		"INSERT INTO chat_messages (user_id, role, content, created_at) VALUES ($1, $2, $3, $4)",
-		userID, role, aesContent, time.Now())
+	if _, err := h.DB.ExecContext(ctx, "DELETE FROM chat_summaries WHERE conversation_id = $1", conversationID); err != nil {
+		return err
+	}
+	if _, err := h.DB.ExecContext(ctx, "DELETE FROM conversation_attachments WHERE conversation_id = $1", conversationID); err != nil {
+		return err
+	}
+	if _, err := h.DB.ExecContext(ctx, "UPDATE conversations SET title = NULL WHERE id = $1", conversationID); err != nil { // A new title is generated for the next topic
+		return err
+	}
+	_, err = h.DB.ExecContext(ctx, "DELETE FROM chat_messages WHERE conversation_id = $1", conversationID)
 	return err
 }
 
-func (h *NeuralHandler) getMessages(ctx context.Context, userID int64, limit int) ([]models.Message, error) { // Getting messages in the database
+func (h *NeuralHandler) saveMessage(ctx context.Context, userID, conversationID int64, role, content, model string) (int64, error) { // Saving a message to the database, model is the one that wrote an assistant answer
+	aesContent, err := utils.EncryptMessage(content)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	var id int64
+	err = h.DB.QueryRowContext(ctx,
+		"INSERT INTO chat_messages (user_id, conversation_id, role, content, model, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		userID, conversationID, role, aesContent, sql.NullString{String: model, Valid: model != ""}, now).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	_, err = h.DB.ExecContext(ctx, "UPDATE conversations SET updated_at = $1 WHERE id = $2", now, conversationID) // Recent conversations are listed first
+	return id, err
+}
+
+// saveAnswer saves the assistant message and the tokens spent on it. The reasoning is left out:
+// providers reject it in the history, and it would take the place of real turns in the context anyway.
+func (h *NeuralHandler) saveAnswer(ctx context.Context, userID, conversationID int64, response models.Completion, route models.ModelRoute) (int64, error) {
+	id, err := h.saveMessage(ctx, userID, conversationID, "assistant", response.Content, route.String())
+	if err != nil {
+		return 0, fmt.Errorf("failed to save assistant message: %w", err)
+	}
+	if err := h.saveUsage(ctx, userID, id, route.String(), response.Usage); err != nil {
+		return 0, fmt.Errorf("failed to save token usage: %w", err)
+	}
+	return id, nil
+}
+
+type storedMessage struct { // A message of the history together with its row ID
+	ID int64
+	models.Message
+}
+
+func (h *NeuralHandler) getMessages(ctx context.Context, conversationID, afterID int64, limit int) ([]storedMessage, error) { // Getting the newest messages of the conversation with ID greater than afterID in the database
 	rows, err := h.DB.QueryContext(ctx,
-		`SELECT role, content 
+		`SELECT id, role, content 
 		FROM chat_messages 
-		WHERE user_id = $1 
+		WHERE conversation_id = $1 AND id > $2
 		ORDER BY created_at DESC 
-		LIMIT $2`,
-		userID, limit)
+		LIMIT $3`,
+		conversationID, afterID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("db query failed: %w", err)
 	}
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Changes the output order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
+func (h *NeuralHandler) firstMessages(ctx context.Context, conversationID int64, limit int) ([]storedMessage, error) { // The oldest messages of the conversation, in order
+	rows, err := h.DB.QueryContext(ctx,
+		"SELECT id, role, content FROM chat_messages WHERE conversation_id = $1 ORDER BY id ASC LIMIT $2",
+		conversationID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	return scanMessages(rows)
+}
+
+func scanMessages(rows *sql.Rows) ([]storedMessage, error) { // Reads and decrypts the messages of a query, closes rows
 	defer rows.Close()
 
-	var messages []models.Message
+	var messages []storedMessage
 	for rows.Next() {
-		var (
-			msg models.Message
-		)
-		if err := rows.Scan(&msg.Role, &msg.Content); err != nil {
+		var msg storedMessage
+		if err := rows.Scan(&msg.ID, &msg.Role, &msg.Content); err != nil {
 			return nil, fmt.Errorf("row scan failed: %w", err)
 		}
+		var err error
 		msg.Content, err = utils.DecryptMessage(msg.Content)
 		if err != nil {
 			return nil, fmt.Errorf("decryption failed: %w", err)
 		}
 		messages = append(messages, msg)
 	}
-
-	// Changes the output order
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
-	}
-
-	return messages, nil
+	return messages, rows.Err()
 }
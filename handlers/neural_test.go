@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"quokka-ai-bot/models"
+	"strings"
+	"testing"
+)
+
+type lengthEstimator struct{} // One token per byte, so sizes in the tests are easy to count
+
+func (lengthEstimator) EstimateTokens(text string) int { return len(text) }
+
+func TestFitContext(t *testing.T) {
+	msg := func(role string, size int) models.Message { // Every message costs size + 4 tokens of overhead
+		return models.Message{Role: role, Content: strings.Repeat("x", size)}
+	}
+	history := []models.Message{
+		msg("system", 6),     // 10
+		msg("user", 16),      // 20
+		msg("assistant", 16), // 20
+		msg("user", 16),      // 20
+		msg("assistant", 16), // 20
+		msg("user", 6),       // 10, the question being asked
+	}
+	tests := []struct {
+		name     string
+		messages []models.Message
+		budget   int
+		want     []int // Indexes of the kept messages
+	}{
+		{"everything fits", history, 200, []int{0, 1, 2, 3, 4, 5}},
+		{"exactly at the budget", history, 100, []int{0, 1, 2, 3, 4, 5}},
+		{"one token short drops the oldest turn", history, 99, []int{0, 3, 4, 5}},
+		{"room for one earlier turn", history, 60, []int{0, 3, 4, 5}},
+		{"dangling answer is dropped", history, 50, []int{0, 5}},
+		{"pinned messages over the budget", history, 5, []int{0, 5}},
+		{"single question", history[:2], 1, []int{0, 1}},
+		{"no system prompt", history[1:], 50, []int{2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &NeuralHandler{ContextTokens: tt.budget, Estimator: lengthEstimator{}}
+			got := h.fitContext(tt.messages)
+			if len(got) != len(tt.want) {
+				t.Fatalf("kept %d messages, want %d", len(got), len(tt.want))
+			}
+			for i, j := range tt.want {
+				if got[i].Role != tt.messages[j].Role || got[i].Content != tt.messages[j].Content {
+					t.Errorf("message %d is not message %d of the input", i, j)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Tasks: Predefined personas that change how the neural network answers, the persona chosen by each user.
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"quokka-ai-bot/i18n"
+	"quokka-ai-bot/models"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+const defaultSystemPrompt = `You are Quokka, a helpful assistant in a Telegram bot.
+Answer in the language the user writes in. Be accurate and concise, say so when you are not sure.
+Refuse to help with illegal, dangerous or harmful activities.`
+
+type Persona struct {
+	ID     string // Stored in the database, used in button data and as the key of the title in the catalog
+	Prompt string // Added to the system prompt
+}
+
+func (p Persona) Title(lang string) string {
+	return i18n.T(lang, "persona."+p.ID)
+}
+
+var personas = []Persona{
+	{
+		ID: "default",
+	},
+	{
+		ID:     "translator",
+		Prompt: "Act as a professional translator. Translate every user message: Russian into English, any other language into Russian. Preserve meaning, tone and formatting, and reply with the translation only unless asked otherwise.",
+	},
+	{
+		ID:     "reviewer",
+		Prompt: "Act as a senior software engineer doing a code review. Point out bugs, security issues, performance problems and unclear code, ordered by severity, and suggest concrete fixes with code.",
+	},
+	{
+		ID:     "tutor",
+		Prompt: "Act as a patient tutor. Explain step by step with simple examples, check understanding with short questions and do not just hand out final answers to exercises.",
+	},
+	{
+		ID:     "editor",
+		Prompt: "Act as a text editor. Fix grammar, spelling and style of the user's text, keep its meaning and voice, then briefly list the main changes.",
+	},
+}
+
+func findPersona(id string) (Persona, bool) {
+	for _, p := range personas {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return personas[0], false
+}
+
+// systemMessage builds the system message from the operator prompt and the persona of the user.
+func (h *NeuralHandler) systemMessage(persona Persona) models.Message {
+	parts := []string{h.SystemPrompt}
+	if persona.Prompt != "" {
+		parts = append(parts, persona.Prompt)
+	}
+	return models.Message{Role: "system", Content: strings.Join(parts, "\n\n")}
+}
+
+// Persona returns the persona chosen by the user, the default one if nothing was chosen.
+func (h *NeuralHandler) Persona(ctx context.Context, userID int64) (Persona, error) {
+	var id string
+	err := h.DB.QueryRowContext(ctx, "SELECT persona FROM user_personas WHERE user_id = $1", userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return personas[0], nil
+	}
+	if err != nil {
+		return personas[0], err
+	}
+	persona, _ := findPersona(id) // Personas removed from the code fall back to the default one
+	return persona, nil
+}
+
+func (h *NeuralHandler) SetPersona(ctx context.Context, userID int64, id string) (Persona, error) {
+	persona, ok := findPersona(id)
+	if !ok {
+		return Persona{}, errors.New("unknown persona " + id)
+	}
+	_, err := h.DB.ExecContext(ctx,
+		`INSERT INTO user_personas (user_id, persona, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET persona = EXCLUDED.persona, updated_at = EXCLUDED.updated_at`,
+		userID, persona.ID, time.Now())
+	return persona, err
+}
+
+func (h *TelegramHandler) sendPersonaMenu(c telebot.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	if !c.Message().Private() && !h.isGroupAdmin(c) { // Groups have one persona for all members
+		return c.Send(h.text(c, "commands.admins_only"))
+	}
+	persona, err := h.Neural.Persona(ctx, personaOwner(c))
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Persona error for user %d %s: %v", c.Sender().ID, c.Sender().Username, err)
+	}
+	return c.Send(messagePersona(h.lang(c), persona), personaMarkup(h.lang(c), persona.ID), telebot.ModeHTML)
+}
+
+func messagePersona(lang string, current Persona) string {
+	return i18n.T(lang, "persona.text", html.EscapeString(current.Title(lang)))
+}
+
+func personaMarkup(lang, current string) *telebot.ReplyMarkup { // Inline keyboard with all personas, the current one is marked
+	markup := &telebot.ReplyMarkup{}
+	rows := make([]telebot.Row, 0, len(personas))
+	for _, p := range personas {
+		title := p.Title(lang)
+		if p.ID == current {
+			title = "✅ " + title
+		}
+		rows = append(rows, markup.Row(markup.Data(title, personaButton, p.ID)))
+	}
+	markup.Inline(rows...)
+	return markup
+}
+
+func personaOwner(c telebot.Context) int64 { // Personas are chosen per user in private chats and per chat in groups
+	if c.Chat().Type == telebot.ChatPrivate {
+		return c.Sender().ID
+	}
+	return c.Chat().ID
+}
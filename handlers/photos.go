@@ -0,0 +1,85 @@
+// Tasks: Receiving photos, they are answered by a vision model with the caption as the question.
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+const photoFileLimit = 20 << 20 // Bytes, bots cannot download larger files from Telegram
+
+func (h *TelegramHandler) HandlePhoto(c telebot.Context) error {
+	startTime := time.Now()
+	user := c.Sender()
+	msg := c.Message()
+	caption := strings.TrimSpace(msg.Caption)
+	if !msg.Private() {
+		if h.mention != nil && h.mention.MatchString(caption) {
+			caption = strings.TrimSpace(h.mention.ReplaceAllString(caption, ""))
+		} else if !h.groupAddressed(c) { // In groups the bot answers only photos addressed to it
+			return nil
+		}
+	}
+	if len(h.Neural.Vision) == 0 {
+		return c.Send(h.text(c, "photos.unsupported"))
+	}
+	if msg.Photo.FileSize > photoFileLimit {
+		return c.Send(h.text(c, "photos.too_large"))
+	}
+
+	allowed, waitTime, err := h.checkRateLimitMessage(user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+	} else if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.request", waitTime.Seconds()))
+	}
+	h.Logger.Printf("Photo from %d %s in %d: %.100s...", user.ID, user.Username, c.Chat().ID, caption)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+	if reply, exceeded := h.checkQuota(ctx, h.lang(c), user); exceeded {
+		return c.Send(reply)
+	}
+	dialog := h.dialogOf(ctx, c)
+	if !dialog.private() && caption != "" { // The model has to know who of the members is speaking
+		caption = authorName(user) + ": " + caption
+	}
+
+	data, err := h.downloadPhoto(msg.Photo) // Telegram gives the largest size of the photo in Message.Photo
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to download photo of %d: %v", user.ID, err)
+		return c.Send(h.text(c, "photos.download_failed"))
+	}
+	if err := c.Notify(telebot.Typing); err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to send typing action %d %s: %v", user.ID, user.Username, err)
+	}
+	placeholder, err := h.sendPlaceholder(c, h.text(c, "answer.looking"))
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to send placeholder to %d: %v", user.ID, err)
+		return c.Send(h.text(c, "answer.send_failed"))
+	}
+
+	img := Image{MIME: "image/jpeg", Data: data} // Telegram recompresses photos to JPEG
+	ok, err := h.streamAnswer(c, placeholder, func(onDelta func(string)) (Answer, error) {
+		return h.Neural.HandleImageStream(ctx, dialog, img, caption, replyOf(msg), onDelta)
+	})
+	if ok {
+		h.Logger.Printf("Successfully responded to %d in %v", user.ID, time.Since(startTime))
+	}
+	return err
+}
+
+func (h *TelegramHandler) downloadPhoto(photo *telebot.Photo) ([]byte, error) {
+	reader, err := h.Bot.File(&photo.File)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading file: %w", err)
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, photoFileLimit))
+}
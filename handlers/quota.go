@@ -0,0 +1,127 @@
+// Tasks: Daily and monthly token quotas of users.
+package handlers
+
+import (
+	"context"
+	"html"
+	"quokka-ai-bot/i18n"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+type QuotaStatus struct {
+	Tier         string
+	DailyLimit   int64 // 0 means unlimited
+	DailyUsed    int64
+	MonthlyLimit int64 // 0 means unlimited
+	MonthlyUsed  int64
+	DailyReset   time.Time // Start of the next UTC day
+	MonthlyReset time.Time // Start of the next UTC month
+}
+
+func (q QuotaStatus) DailyRemaining() int64 {
+	return remaining(q.DailyLimit, q.DailyUsed)
+}
+
+func (q QuotaStatus) MonthlyRemaining() int64 {
+	return remaining(q.MonthlyLimit, q.MonthlyUsed)
+}
+
+func (q QuotaStatus) Exceeded() bool {
+	return q.DailyRemaining() == 0 || q.MonthlyRemaining() == 0
+}
+
+// ResetAt returns the moment when the user can send requests again.
+func (q QuotaStatus) ResetAt() time.Time {
+	if q.MonthlyRemaining() == 0 {
+		return q.MonthlyReset
+	}
+	return q.DailyReset
+}
+
+func remaining(limit, used int64) int64 { // -1 means unlimited
+	if limit == 0 {
+		return -1
+	}
+	return max(limit-used, 0)
+}
+
+// QuotaStatus returns the limits of the user's tier and the tokens spent in the current day and month.
+// Usage is taken from token_usage_daily, so every answer is subtracted from the budget as soon as it is saved.
+func (h *NeuralHandler) QuotaStatus(ctx context.Context, userID int64) (QuotaStatus, error) {
+	tier, limits := h.Quota.TierOf(userID)
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	status := QuotaStatus{
+		Tier:         tier,
+		DailyLimit:   limits.Daily,
+		MonthlyLimit: limits.Monthly,
+		DailyReset:   today.AddDate(0, 0, 1),
+		MonthlyReset: monthStart.AddDate(0, 1, 0),
+	}
+	if limits.Daily == 0 && limits.Monthly == 0 { // Nothing to count
+		return status, nil
+	}
+
+	err := h.DB.QueryRowContext(ctx,
+		`SELECT
+			COALESCE(SUM(prompt_tokens + completion_tokens) FILTER (WHERE day = $2), 0),
+			COALESCE(SUM(prompt_tokens + completion_tokens), 0)
+		FROM token_usage_daily
+		WHERE user_id = $1 AND day >= $3`,
+		userID, today.Format(time.DateOnly), monthStart.Format(time.DateOnly)).Scan(&status.DailyUsed, &status.MonthlyUsed)
+	return status, err
+}
+
+func (h *TelegramHandler) messageLimits(lang string, user *telebot.User) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	status, err := h.Neural.QuotaStatus(ctx, user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Quota error for user %d %s: %v", user.ID, user.Username, err)
+		return i18n.T(lang, "limits.failed")
+	}
+	if status.DailyLimit == 0 && status.MonthlyLimit == 0 {
+		return i18n.T(lang, "limits.none")
+	}
+	return i18n.T(lang, "limits.text",
+		html.EscapeString(status.Tier),
+		formatQuota(lang, status.DailyLimit, status.DailyUsed, status.DailyReset),
+		formatQuota(lang, status.MonthlyLimit, status.MonthlyUsed, status.MonthlyReset))
+}
+
+func formatQuota(lang string, limit, used int64, reset time.Time) string {
+	if limit == 0 {
+		return i18n.T(lang, "limits.unlimited")
+	}
+	return i18n.T(lang, "limits.quota",
+		remaining(limit, used), limit, formatWait(lang, time.Until(reset)), reset.Format("02.01 15:04"))
+}
+
+func formatWait(lang string, d time.Duration) string { // Formats a duration in its two largest units, such as "2 д. 5 ч."
+	d = d.Round(time.Minute)
+	days, hours, minutes := int(d.Hours())/24, int(d.Hours())%24, int(d.Minutes())%60
+	switch {
+	case days > 0:
+		return i18n.T(lang, "duration.days", days, hours)
+	case hours > 0:
+		return i18n.T(lang, "duration.hours", hours, minutes)
+	}
+	return i18n.T(lang, "duration.minutes", max(minutes, 1))
+}
+
+func (h *TelegramHandler) checkQuota(ctx context.Context, lang string, user *telebot.User) (reply string, exceeded bool) { // Returns the text to send if the user has run out of tokens
+	status, err := h.Neural.QuotaStatus(ctx, user.ID)
+	if err != nil { // In case of a database error, we skip the check so as not to block users
+		h.Logger.Printf("[ ERROR ] Quota error for user %d %s: %v", user.ID, user.Username, err)
+		return "", false
+	}
+	if !status.Exceeded() {
+		return "", false
+	}
+	h.Logger.Printf("Quota exceeded for user %d %s", user.ID, user.Username)
+	return i18n.T(lang, "limits.exceeded", formatWait(lang, time.Until(status.ResetAt()))), true
+}
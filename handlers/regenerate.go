@@ -0,0 +1,99 @@
+// Tasks: Regenerating the last answer and asking the model to continue an answer that was cut off.
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"quokka-ai-bot/models"
+	"quokka-ai-bot/utils"
+)
+
+const continuePrompt = "Your previous answer was cut off. Continue it exactly from the place where it stopped, without repeating anything and without any introduction."
+
+// ErrNotLastAnswer is returned when a button of an older answer is pressed: only the latest answer of the active conversation can be changed.
+var ErrNotLastAnswer = errors.New("not the last answer of the active conversation")
+
+// lastAnswer checks that messageID is the newest message of the active conversation of the user and that it is an answer.
+func (h *NeuralHandler) lastAnswer(ctx context.Context, userID, messageID int64) (int64, storedMessage, error) {
+	conversationID, err := h.activeConversation(ctx, userID)
+	if err != nil {
+		return 0, storedMessage{}, err
+	}
+	last, err := h.getMessages(ctx, conversationID, 0, 1)
+	if err != nil {
+		return 0, storedMessage{}, err
+	}
+	if len(last) == 0 || last[0].ID != messageID || last[0].Role != "assistant" {
+		return 0, storedMessage{}, ErrNotLastAnswer
+	}
+	return conversationID, last[0], nil
+}
+
+// Regenerate asks for a new version of the last answer. The previous version is replaced only after the new one is complete.
+func (h *NeuralHandler) Regenerate(ctx context.Context, userID, messageID int64, onDelta func(delta string)) (Answer, error) {
+	conversationID, _, err := h.lastAnswer(ctx, userID, messageID)
+	if err != nil {
+		return Answer{}, err
+	}
+	request, err := h.buildRequest(ctx, userID, conversationID, messageID)
+	if err != nil {
+		return Answer{}, err
+	}
+	ctx, chain := h.applyUserSettings(ctx, userID, &request)
+
+	response, route, err := h.completeWithTools(ctx, request, h.streamTo(chain, onDelta))
+	if err != nil {
+		return Answer{}, err
+	}
+
+	aesContent, err := utils.EncryptMessage(response.Content)
+	if err != nil {
+		return Answer{}, err
+	}
+	// The row is updated in place: its links to Telegram messages stay, and a failure leaves the previous answer whole
+	_, err = h.DB.ExecContext(ctx, "UPDATE chat_messages SET content = $1, model = $2 WHERE id = $3",
+		aesContent, sql.NullString{String: route.String(), Valid: true}, messageID)
+	if err != nil {
+		return Answer{}, fmt.Errorf("failed to save new answer: %w", err)
+	}
+	if err := h.saveUsage(ctx, userID, messageID, route.String(), response.Usage); err != nil {
+		return Answer{}, fmt.Errorf("failed to save token usage: %w", err)
+	}
+	return Answer{MessageID: messageID, Text: response.Content, Reasoning: response.Reasoning}, nil
+}
+
+// Continue asks the model to go on with the last answer and appends the continuation to it.
+// The returned answer contains only the new text.
+func (h *NeuralHandler) Continue(ctx context.Context, userID, messageID int64, onDelta func(delta string)) (Answer, error) {
+	conversationID, last, err := h.lastAnswer(ctx, userID, messageID)
+	if err != nil {
+		return Answer{}, err
+	}
+	request, err := h.buildRequest(ctx, userID, conversationID, 0)
+	if err != nil {
+		return Answer{}, err
+	}
+	ctx, chain := h.applyUserSettings(ctx, userID, &request)
+	request.Messages = append(request.Messages, models.Message{Role: "user", Content: continuePrompt}) // Not stored, the history keeps one whole answer
+
+	response, route, err := h.completeStreamOn(ctx, chain, request, onDelta)
+	if err != nil {
+		return Answer{}, err
+	}
+
+	aesContent, err := utils.EncryptMessage(last.Content + response.Content)
+	if err != nil {
+		return Answer{}, err
+	}
+	_, err = h.DB.ExecContext(ctx, "UPDATE chat_messages SET content = $1, model = $2 WHERE id = $3",
+		aesContent, sql.NullString{String: route.String(), Valid: true}, messageID)
+	if err != nil {
+		return Answer{}, fmt.Errorf("failed to save continuation: %w", err)
+	}
+	if err := h.saveUsage(ctx, userID, messageID, route.String(), response.Usage); err != nil {
+		return Answer{}, fmt.Errorf("failed to save token usage: %w", err)
+	}
+	return Answer{MessageID: messageID, Text: response.Content, Reasoning: response.Reasoning}, nil
+}
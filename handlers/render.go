@@ -0,0 +1,301 @@
+// Tasks: Converting the Markdown of neural network answers into the HTML subset supported by Telegram.
+package handlers
+
+import (
+	"errors"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/telebot.v4"
+)
+
+var (
+	headingRe   = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	bulletRe    = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	orderedRe   = regexp.MustCompile(`^(\s*)(\d+[.)])\s+(.*)$`)
+	ruleRe      = regexp.MustCompile(`^\s{0,3}((-\s*){3,}|(\*\s*){3,}|(_\s*){3,})$`)
+	tableRuleRe = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+)
+
+// renderMarkdown converts Markdown into Telegram HTML: headings become bold lines, lists get bullets,
+// code blocks keep their language and tables are shown as preformatted text. Everything else is escaped.
+func renderMarkdown(text string) string {
+	lines := strings.Split(text, "\n")
+	var out []string
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			lang := strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			out = append(out, renderCode(strings.Join(code, "\n"), lang))
+		case strings.HasPrefix(trimmed, "|") && i+1 < len(lines) && tableRuleRe.MatchString(lines[i+1]):
+			rows := [][]string{tableCells(line)}
+			for i += 2; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				rows = append(rows, tableCells(lines[i]))
+			}
+			i--
+			out = append(out, renderTable(rows))
+		case strings.HasPrefix(trimmed, ">"):
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote = append(quote, renderInline(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">"))))
+			}
+			i--
+			out = append(out, "<blockquote>"+strings.Join(quote, "\n")+"</blockquote>")
+		case ruleRe.MatchString(line):
+			out = append(out, "——————")
+		case headingRe.MatchString(line):
+			heading := strings.NewReplacer("**", "", "__", "").Replace(headingRe.FindStringSubmatch(line)[1]) // The whole heading is bold already
+			out = append(out, "<b>"+renderInline(heading)+"</b>")
+		case bulletRe.MatchString(line):
+			m := bulletRe.FindStringSubmatch(line)
+			out = append(out, m[1]+"• "+renderInline(m[2]))
+		case orderedRe.MatchString(line):
+			m := orderedRe.FindStringSubmatch(line)
+			out = append(out, m[1]+m[2]+" "+renderInline(m[3]))
+		default:
+			out = append(out, renderInline(line))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+func renderCode(code, lang string) string {
+	if lang == "" || strings.ContainsAny(lang, " \"'<>&") {
+		return "<pre>" + html.EscapeString(code) + "</pre>"
+	}
+	return `<pre><code class="language-` + lang + `">` + html.EscapeString(code) + "</code></pre>"
+}
+
+func tableCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	cells := strings.Split(line, "|")
+	for i, cell := range cells {
+		cells[i] = stripInline(strings.TrimSpace(cell))
+	}
+	return cells
+}
+
+func renderTable(rows [][]string) string { // Telegram has no tables, so the columns are aligned in a monospace block
+	var widths []int
+	for _, row := range rows {
+		for j, cell := range row {
+			if j == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[j] = max(widths[j], utf8.RuneCountInString(cell))
+		}
+	}
+	var b strings.Builder
+	for i, row := range rows {
+		if i == 1 { // Line under the header
+			for j, width := range widths {
+				if j > 0 {
+					b.WriteString("-+-")
+				}
+				b.WriteString(strings.Repeat("-", width))
+			}
+			b.WriteString("\n")
+		}
+		for j, cell := range row {
+			if j > 0 {
+				b.WriteString(" | ")
+			}
+			b.WriteString(cell + strings.Repeat(" ", widths[j]-utf8.RuneCountInString(cell)))
+		}
+		b.WriteString("\n")
+	}
+	return "<pre>" + html.EscapeString(strings.TrimRight(b.String(), " \n")) + "</pre>"
+}
+
+var inlineMarkers = []struct {
+	marker, tag string
+}{
+	{"**", "b"},
+	{"__", "b"},
+	{"~~", "s"},
+	{"*", "i"},
+	{"_", "i"},
+}
+
+// renderInline converts emphasis, inline code and links of a single line. Markers without a pair are left as they are.
+func renderInline(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		if rest[0] == '`' {
+			ticks := len(rest) - len(strings.TrimLeft(rest, "`"))
+			if end := strings.Index(rest[ticks:], rest[:ticks]); end > 0 {
+				b.WriteString("<code>" + html.EscapeString(strings.TrimSpace(rest[ticks:ticks+end])) + "</code>")
+				i += 2*ticks + end
+				continue
+			}
+			b.WriteString(html.EscapeString(rest[:ticks]))
+			i += ticks
+			continue
+		}
+		if rest[0] == '[' {
+			if label, url, n, ok := parseLink(rest); ok {
+				b.WriteString(`<a href="` + html.EscapeString(url) + `">` + renderInline(label) + "</a>")
+				i += n
+				continue
+			}
+		}
+		if inner, tag, n, ok := parseEmphasis(text, i); ok {
+			b.WriteString("<" + tag + ">" + renderInline(inner) + "</" + tag + ">")
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(rest)
+		b.WriteString(html.EscapeString(string(r)))
+		i += size
+	}
+	return b.String()
+}
+
+func parseLink(text string) (label, url string, n int, ok bool) { // Parses [label](url) at the start of the text
+	closeLabel := strings.Index(text, "](")
+	if closeLabel < 1 {
+		return "", "", 0, false
+	}
+	closeURL := strings.IndexByte(text[closeLabel:], ')')
+	if closeURL < 0 {
+		return "", "", 0, false
+	}
+	url = strings.TrimSpace(text[closeLabel+2 : closeLabel+closeURL])
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "tg://") {
+		return "", "", 0, false // Telegram rejects links it cannot open
+	}
+	return text[1:closeLabel], url, closeLabel + closeURL + 1, true
+}
+
+// parseEmphasis parses **bold**, *italic*, ~~strike~~ and their underscore variants at position i.
+// Like in Markdown, a closing marker belongs to the nearest opening one before it.
+func parseEmphasis(text string, i int) (inner, tag string, n int, ok bool) {
+	for _, m := range inlineMarkers {
+		if !strings.HasPrefix(text[i:], m.marker) {
+			continue
+		}
+		if !canOpen(text, i, m.marker) {
+			return "", "", 0, false
+		}
+		start := i + len(m.marker)
+		for j := start + 1; j+len(m.marker) <= len(text); j++ {
+			if !strings.HasPrefix(text[j:], m.marker) {
+				continue
+			}
+			if canClose(text, j, m.marker) {
+				return text[start:j], m.tag, j + len(m.marker) - i, true
+			}
+			if canOpen(text, j, m.marker) {
+				return "", "", 0, false
+			}
+		}
+		return "", "", 0, false
+	}
+	return "", "", 0, false
+}
+
+func canOpen(text string, i int, marker string) bool { // The marker at i is followed by text
+	start := i + len(marker)
+	if start >= len(text) || isSpaceAt(text, start) {
+		return false
+	}
+	if len(marker) == 1 && (text[start] == marker[0] || i > 0 && text[i-1] == marker[0]) { // Part of a double marker
+		return false
+	}
+	return marker[0] != '_' || !isWordBefore(text, i) // snake_case identifiers are not emphasis
+}
+
+func canClose(text string, j int, marker string) bool { // The marker at j follows text
+	end := j + len(marker)
+	if j == 0 || isSpaceBefore(text, j) {
+		return false
+	}
+	if len(marker) == 1 && (text[j-1] == marker[0] || end < len(text) && text[end] == marker[0]) {
+		return false
+	}
+	return marker[0] != '_' || end >= len(text) || !isWordAt(text, end)
+}
+
+func isSpaceAt(text string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(text[i:])
+	return unicode.IsSpace(r)
+}
+
+func isSpaceBefore(text string, i int) bool {
+	r, _ := utf8.DecodeLastRuneInString(text[:i])
+	return unicode.IsSpace(r)
+}
+
+func isWordAt(text string, i int) bool { // Reports whether the character at i is a letter or a digit
+	r, _ := utf8.DecodeRuneInString(text[i:])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isWordBefore(text string, i int) bool { // Reports whether the character before i is a letter or a digit
+	r, _ := utf8.DecodeLastRuneInString(text[:i])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+var stripRe = regexp.MustCompile("\\*\\*|__|~~|`")
+
+func stripInline(text string) string { // Removes emphasis markers from text that is shown as is
+	return stripRe.ReplaceAllString(text, "")
+}
+
+var tagRe = regexp.MustCompile(`<[^>]*>`)
+
+func renderedLength(text string) int { // Length of the rendered text as Telegram counts it: tags are markup, entities are one character
+	return textLength(html.UnescapeString(tagRe.ReplaceAllString(renderMarkdown(text), "")))
+}
+
+// splitRendered splits the text like splitMessage, but so that every chunk fits into limit after rendering.
+// Aligned tables and rules are longer than their Markdown, a chunk that grows too long is split again with a smaller budget.
+func splitRendered(text string, limit int) []string {
+	var chunks []string
+	for _, chunk := range splitMessage(text, limit) {
+		parts := []string{chunk}
+		for budget := textLength(chunk); budget > 1; {
+			over := 0
+			for _, part := range parts {
+				over = max(over, renderedLength(part)-limit)
+			}
+			if over <= 0 {
+				break
+			}
+			budget = max(budget-over, 1)
+			parts = splitMessage(chunk, budget)
+		}
+		chunks = append(chunks, parts...)
+	}
+	return chunks
+}
+
+// sendMarkdown sends the rendered text with send. If Telegram rejects the markup, the original text is sent as is.
+func sendMarkdown(text string, send func(text string, opts ...interface{}) (*telebot.Message, error), opts ...interface{}) (*telebot.Message, error) {
+	msg, err := send(renderMarkdown(text), append(opts, telebot.ModeHTML)...)
+	if isEntityError(err) {
+		log.Printf("[ WARN ] Telegram rejected the rendered answer, sending plain text: %v", err)
+		return send(text, opts...)
+	}
+	return msg, err
+}
+
+func isEntityError(err error) bool { // Telegram could not parse the markup of a message
+	var tgErr *telebot.Error
+	if errors.As(err, &tgErr) {
+		return strings.Contains(tgErr.Description, "can't parse entities")
+	}
+	return err != nil && strings.Contains(err.Error(), "can't parse entities")
+}
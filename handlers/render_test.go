@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain text is escaped", "a < b & c > d", "a &lt; b &amp; c &gt; d"},
+		{"bold and italic", "**bold** and *italic*", "<b>bold</b> and <i>italic</i>"},
+		{"underscores", "__bold__ _italic_", "<b>bold</b> <i>italic</i>"},
+		{"strikethrough", "~~old~~ new", "<s>old</s> new"},
+		{"nested emphasis", "**bold _and italic_**", "<b>bold <i>and italic</i></b>"},
+		{"snake_case is not emphasis", "use snake_case_name here", "use snake_case_name here"},
+		{"unpaired marker", "2 * 3 = 6", "2 * 3 = 6"},
+		{"inline code", "run `a < b` now", "run <code>a &lt; b</code> now"},
+		{"link", "[docs](https://example.com/?a=1&b=2)", `<a href="https://example.com/?a=1&amp;b=2">docs</a>`},
+		{"unsafe link is left as text", "[x](javascript:alert(1))", "[x](javascript:alert(1))"},
+		{"heading", "## Title **bold**", "<b>Title bold</b>"},
+		{"bullet list", "- one\n* two\n  + nested", "• one\n• two\n  • nested"},
+		{"ordered list", "1. one\n2) two", "1. one\n2) two"},
+		{"rule", "---", "——————"},
+		{"quote", "> first\n> **second**", "<blockquote>first\n<b>second</b></blockquote>"},
+		{"code block with language", "```go\nif a < b {}\n```", `<pre><code class="language-go">if a &lt; b {}</code></pre>`},
+		{"code block without language", "```\n**not bold**\n```", "<pre>**not bold**</pre>"},
+		{"unsafe language is dropped", "```a\"b\nx\n```", "<pre>x</pre>"},
+		{"unclosed code block", "```\ncode", "<pre>code</pre>"},
+		{
+			"table",
+			"| Name | Qty |\n|------|----:|\n| **apple** | 10 |\n| kiwi | 2 |",
+			"<pre>Name  | Qty\n------+----\napple | 10 \nkiwi  | 2</pre>",
+		},
+		{"pipe without a table", "| not a table", "| not a table"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderMarkdown(tt.text); got != tt.want {
+				t.Errorf("renderMarkdown(%q) =\n%q\nwant\n%q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitRenderedFitsAfterRendering(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("| a | b |\n|---|---|\n")
+	for i := range 600 {
+		b.WriteString("| x | " + strings.Repeat("y", i%40) + " |\n")
+	}
+	text := b.String()
+	if renderedLength(splitMessage(text, messageLimit)[0]) <= messageLimit {
+		t.Fatal("the table no longer grows when rendered, the test does not check anything")
+	}
+	for i, chunk := range splitRendered(text, messageLimit) {
+		if n := renderedLength(chunk); n > messageLimit {
+			t.Errorf("chunk %d is %d long after rendering", i, n)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Tasks: Adding the message a user replied to into the request, so follow-ups about older answers have their context.
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"quokka-ai-bot/models"
+	"quokka-ai-bot/utils"
+)
+
+const replyQuoteLimit = 4000 // Characters of the replied message added to the request
+
+// ReplyTo is the message a question answers in Telegram. The zero value means the question is not a reply.
+type ReplyTo struct {
+	MessageID int    // Telegram ID of the message
+	Text      string // Its text as Telegram shows it, used if the bot has not stored the message
+	Quote     string // The part of the message the user selected, if any
+}
+
+// LinkMessages remembers which Telegram messages show the stored message messageID.
+func (h *NeuralHandler) LinkMessages(ctx context.Context, chatID, messageID int64, tgMessageIDs []int) error {
+	for _, tgID := range tgMessageIDs {
+		_, err := h.DB.ExecContext(ctx,
+			`INSERT INTO message_links (chat_id, tg_message_id, message_id) VALUES ($1, $2, $3)
+			ON CONFLICT (chat_id, tg_message_id) DO UPDATE SET message_id = EXCLUDED.message_id`,
+			chatID, tgID, messageID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LinkedParts returns the Telegram messages that show the stored message messageID, in the order they were sent.
+func (h *NeuralHandler) LinkedParts(ctx context.Context, chatID, messageID int64) ([]int, error) {
+	rows, err := h.DB.QueryContext(ctx,
+		"SELECT tg_message_id FROM message_links WHERE chat_id = $1 AND message_id = $2 ORDER BY tg_message_id",
+		chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var parts []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		parts = append(parts, id)
+	}
+	return parts, rows.Err()
+}
+
+func (h *NeuralHandler) UnlinkMessages(ctx context.Context, chatID int64, tgMessageIDs []int) error { // Forgets Telegram messages that no longer show a stored message
+	for _, tgID := range tgMessageIDs {
+		if _, err := h.DB.ExecContext(ctx, "DELETE FROM message_links WHERE chat_id = $1 AND tg_message_id = $2", chatID, tgID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkedMessage returns the full stored text shown by a Telegram message. ok is false if the bot does not know the message.
+func (h *NeuralHandler) linkedMessage(ctx context.Context, chatID int64, tgMessageID int) (text string, ok bool, err error) {
+	err = h.DB.QueryRowContext(ctx,
+		`SELECT m.content FROM message_links l JOIN chat_messages m ON m.id = l.message_id
+		WHERE l.chat_id = $1 AND l.tg_message_id = $2`,
+		chatID, tgMessageID).Scan(&text)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	text, err = utils.DecryptMessage(text)
+	return text, err == nil, err
+}
+
+// addReplyContext puts the replied message in front of the question, unless it is still part of the history anyway.
+// Only the request is changed, the stored question stays as the user wrote it.
+func (h *NeuralHandler) addReplyContext(ctx context.Context, d Dialog, reply ReplyTo, request *models.ChatRequest) error {
+	if reply.MessageID == 0 && reply.Text == "" {
+		return nil
+	}
+	text := reply.Text
+	if reply.MessageID != 0 {
+		stored, ok, err := h.linkedMessage(ctx, d.ChatID, reply.MessageID)
+		if err != nil {
+			return err
+		}
+		if ok { // Telegram shows only a part of long answers, the stored one is complete
+			text = stored
+		}
+	}
+	if text == "" {
+		return nil
+	}
+
+	last := len(request.Messages) - 1
+	for _, msg := range request.Messages[:last] {
+		if msg.Content == text {
+			return nil
+		}
+	}
+	text, _ = truncateRunes(text, replyQuoteLimit)
+	prefix := fmt.Sprintf("The user is replying to this earlier message:\n\"\"\"\n%s\n\"\"\"\n", text)
+	if reply.Quote != "" {
+		prefix += fmt.Sprintf("They quoted this part of it: \"%s\"\n", reply.Quote)
+	}
+	request.Messages[last].Content = prefix + "\n" + request.Messages[last].Content
+	return nil
+}
@@ -0,0 +1,294 @@
+// Tasks: Preferences every user can change for their own answers.
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"log"
+	"quokka-ai-bot/i18n"
+	"quokka-ai-bot/models"
+	"quokka-ai-bot/tools"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+const ( // Names of the settings, used in button data
+	settingModel       = "model"
+	settingTemperature = "temperature"
+	settingLength      = "length"
+	settingLanguage    = "language"
+	settingFormat      = "format"
+)
+
+type UserSettings struct {
+	ShowReasoning bool   // Show the thinking of reasoning models above the answer
+	Model         string // Route of the model asked first, empty keeps the configured order
+	Temperature   string // The other fields are IDs of presetOptions, empty is the default option
+	AnswerLength  string
+	Language      string
+	Format        string
+	Locale        string // Interface language, empty follows the Telegram client
+	Timezone      string // IANA time zone for the date and time tool, empty means the one from the config
+}
+
+var defaultUserSettings = UserSettings{}
+
+type SettingOption struct {
+	ID          string   // Stored in the database, empty for the default option
+	Title       string   // Shown on the button as is, the presets take their titles from the catalog
+	Prompt      string   // Added to the system prompt
+	Temperature *float64 // Nil keeps the default of the model
+	MaxTokens   int      // Zero does not limit the answer
+}
+
+func temperature(t float64) *float64 { return &t }
+
+var presetOptions = map[string][]SettingOption{
+	settingTemperature: {
+		{ID: "precise", Temperature: temperature(0.2)},
+		{ID: ""},
+		{ID: "creative", Temperature: temperature(1.2)},
+	},
+	settingLength: {
+		{ID: "short", Prompt: "Keep answers short: a few sentences, unless the user explicitly asks for more.", MaxTokens: 600},
+		{ID: ""},
+		{ID: "long", Prompt: "Give detailed and thorough answers with explanations and examples."},
+	},
+	settingLanguage: {
+		{ID: ""},
+		{ID: "ru", Prompt: "Always answer in Russian, whatever language the user writes in."},
+		{ID: "en", Prompt: "Always answer in English, whatever language the user writes in."},
+		{ID: "de", Prompt: "Always answer in German, whatever language the user writes in."},
+		{ID: "es", Prompt: "Always answer in Spanish, whatever language the user writes in."},
+		{ID: "fr", Prompt: "Always answer in French, whatever language the user writes in."},
+	},
+	settingFormat: {
+		{ID: ""},
+		{ID: "plain", Prompt: "Do not use Markdown or any other formatting, answer in plain text."},
+		{ID: "structured", Prompt: "Structure answers with short headings, bullet lists and tables where they help."},
+	},
+}
+
+// settingOptions returns the options of a setting in the order they are shown. The models are the routes of the chain.
+func (h *NeuralHandler) settingOptions(setting string) []SettingOption {
+	if setting != settingModel {
+		return presetOptions[setting]
+	}
+	options := []SettingOption{{ID: ""}}
+	for _, route := range h.Chain {
+		options = append(options, SettingOption{ID: route.String(), Title: route.String()})
+	}
+	return options
+}
+
+// chosenOption finds the chosen option of a setting, the default one if the stored ID is no longer offered.
+func (h *NeuralHandler) chosenOption(setting, id string) SettingOption {
+	options := h.settingOptions(setting)
+	for _, o := range options {
+		if o.ID == id {
+			return o
+		}
+	}
+	for _, o := range options {
+		if o.ID == "" {
+			return o
+		}
+	}
+	return SettingOption{}
+}
+
+func (s UserSettings) answerStyle() string { // The settings that change answers, the interface language and reasoning only change how they are shown
+	return strings.Join([]string{s.Model, s.Temperature, s.AnswerLength, s.Language, s.Format}, "|")
+}
+
+func (s *UserSettings) get(setting string) string {
+	switch setting {
+	case settingModel:
+		return s.Model
+	case settingTemperature:
+		return s.Temperature
+	case settingLength:
+		return s.AnswerLength
+	case settingLanguage:
+		return s.Language
+	case settingFormat:
+		return s.Format
+	}
+	return ""
+}
+
+func (s *UserSettings) set(setting, id string) {
+	switch setting {
+	case settingModel:
+		s.Model = id
+	case settingTemperature:
+		s.Temperature = id
+	case settingLength:
+		s.AnswerLength = id
+	case settingLanguage:
+		s.Language = id
+	case settingFormat:
+		s.Format = id
+	}
+}
+
+// UserSettings returns the settings of a user, the default ones if the user has not changed anything.
+func (h *NeuralHandler) UserSettings(ctx context.Context, userID int64) (UserSettings, error) {
+	var s UserSettings
+	err := h.DB.QueryRowContext(ctx,
+		"SELECT show_reasoning, model, temperature, answer_length, language, response_format, locale, timezone FROM user_settings WHERE user_id = $1",
+		userID).Scan(&s.ShowReasoning, &s.Model, &s.Temperature, &s.AnswerLength, &s.Language, &s.Format, &s.Locale, &s.Timezone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultUserSettings, nil
+	}
+	if err != nil {
+		return defaultUserSettings, err
+	}
+	return s, nil
+}
+
+func (h *NeuralHandler) SetUserSettings(ctx context.Context, userID int64, s UserSettings) error {
+	_, err := h.DB.ExecContext(ctx,
+		`INSERT INTO user_settings (user_id, show_reasoning, model, temperature, answer_length, language, response_format, locale, timezone, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (user_id) DO UPDATE SET show_reasoning = EXCLUDED.show_reasoning, model = EXCLUDED.model,
+			temperature = EXCLUDED.temperature, answer_length = EXCLUDED.answer_length, language = EXCLUDED.language,
+			response_format = EXCLUDED.response_format, locale = EXCLUDED.locale, timezone = EXCLUDED.timezone, updated_at = EXCLUDED.updated_at`,
+		userID, s.ShowReasoning, s.Model, s.Temperature, s.AnswerLength, s.Language, s.Format, s.Locale, s.Timezone, time.Now())
+	return err
+}
+
+// applyUserSettings adds the preferences of the user to the request and returns the chain to ask, the chosen model first,
+// and the context for the request, which carries the time zone of the user for the tools.
+// Settings that cannot be read leave the defaults, the answer matters more than its style.
+func (h *NeuralHandler) applyUserSettings(ctx context.Context, userID int64, request *models.ChatRequest) (context.Context, []models.ModelRoute) {
+	settings, err := h.UserSettings(ctx, userID)
+	if err != nil {
+		log.Printf("[ WARN ] Failed to get settings of %d: %v", userID, err)
+		return ctx, h.Chain
+	}
+	if settings.Timezone != "" {
+		if location, err := time.LoadLocation(settings.Timezone); err == nil {
+			ctx = tools.WithLocation(ctx, location)
+		}
+	}
+	var prompts []string
+	for _, setting := range []string{settingTemperature, settingLength, settingLanguage, settingFormat} {
+		option := h.chosenOption(setting, settings.get(setting))
+		if option.Prompt != "" {
+			prompts = append(prompts, option.Prompt)
+		}
+		if option.Temperature != nil {
+			request.Temperature = option.Temperature
+		}
+		if option.MaxTokens != 0 {
+			request.MaxTokens = option.MaxTokens
+		}
+	}
+	if len(prompts) > 0 && len(request.Messages) > 0 && request.Messages[0].Role == "system" {
+		request.Messages[0].Content += "\n\n" + strings.Join(prompts, " ")
+	}
+	return ctx, h.chainFrom(settings.Model)
+}
+
+func (h *NeuralHandler) chainFrom(model string) []models.ModelRoute { // The chain starting with the chosen model, the rest stay as fallbacks
+	for i, route := range h.Chain {
+		if model != "" && route.String() == model {
+			chain := append([]models.ModelRoute{route}, h.Chain[:i]...)
+			return append(chain, h.Chain[i+1:]...)
+		}
+	}
+	return h.Chain
+}
+
+func (h *TelegramHandler) sendSettings(c telebot.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	settings, err := h.Neural.UserSettings(ctx, c.Sender().ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] User settings error for user %d %s: %v", c.Sender().ID, c.Sender().Username, err)
+		return c.Send(h.text(c, "settings.get_failed"))
+	}
+	return c.Send(h.messageSettings(h.lang(c), settings), settingsMarkup(h.lang(c), settings), telebot.ModeHTML)
+}
+
+const ( // Data of the settings buttons besides the names of the settings
+	settingsMenu      = "menu"
+	settingsReasoning = "reasoning"
+)
+
+var settingsOrder = []string{settingModel, settingTemperature, settingLength, settingLanguage, settingFormat}
+
+func (h *TelegramHandler) messageSettings(lang string, settings UserSettings) string {
+	var b strings.Builder
+	b.WriteString(i18n.T(lang, "settings.text"))
+	for _, setting := range settingsOrder {
+		option := h.Neural.chosenOption(setting, settings.get(setting))
+		b.WriteString(i18n.T(lang, "settings.line", settingTitle(lang, setting), html.EscapeString(optionTitle(lang, setting, option))))
+	}
+	reasoning := i18n.T(lang, "settings.reasoning_off")
+	if settings.ShowReasoning {
+		reasoning = i18n.T(lang, "settings.reasoning_on")
+	}
+	b.WriteString(i18n.T(lang, "settings.reasoning", reasoning))
+	return b.String()
+}
+
+func settingTitle(lang, setting string) string {
+	return i18n.T(lang, "settings."+setting+".title")
+}
+
+func optionTitle(lang, setting string, o SettingOption) string { // Model routes are shown as they are, the presets are translated
+	if o.Title != "" {
+		return o.Title
+	}
+	id := o.ID
+	if id == "" {
+		id = "default"
+	}
+	return i18n.T(lang, "settings."+setting+"."+id)
+}
+
+func settingsMarkup(lang string, settings UserSettings) *telebot.ReplyMarkup { // A button for every setting, two in a row
+	markup := &telebot.ReplyMarkup{}
+	var rows []telebot.Row
+	var row telebot.Row
+	for _, setting := range settingsOrder {
+		row = append(row, markup.Data(settingTitle(lang, setting), settingsButton, setting))
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	row = append(row, markup.Data(onOff(settings.ShowReasoning)+" "+i18n.T(lang, "settings.reasoning_button"), settingsButton, settingsReasoning))
+	markup.Inline(append(rows, row)...)
+	return markup
+}
+
+func messageSettingOptions(lang, setting string) string {
+	return i18n.T(lang, "settings.options", settingTitle(lang, setting), i18n.T(lang, "settings."+setting+".hint"))
+}
+
+// settingOptionsMarkup lists the options of a setting, the current one is marked. Buttons carry the index of the option:
+// model routes can be longer than the 64 bytes Telegram allows for button data.
+func (h *TelegramHandler) settingOptionsMarkup(lang, setting, current string) *telebot.ReplyMarkup {
+	markup := &telebot.ReplyMarkup{}
+	options := h.Neural.settingOptions(setting)
+	rows := make([]telebot.Row, 0, len(options)+1)
+	for i, o := range options {
+		title := optionTitle(lang, setting, o)
+		if o.ID == h.Neural.chosenOption(setting, current).ID {
+			title = "✅ " + title
+		}
+		rows = append(rows, markup.Row(markup.Data(title, settingsButton, setting, strconv.Itoa(i))))
+	}
+	rows = append(rows, markup.Row(markup.Data(i18n.T(lang, "settings.back"), settingsButton, settingsMenu)))
+	markup.Inline(rows...)
+	return markup
+}
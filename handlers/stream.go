@@ -0,0 +1,238 @@
+// Tasks: Showing a neural network answer while it is being generated by editing a placeholder message.
+package handlers
+
+import (
+	"context"
+	"errors"
+	"html"
+	"quokka-ai-bot/i18n"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+const (
+	streamEditInterval = 1500 * time.Millisecond // Telegram allows roughly one edit per second in a chat, leave some headroom
+	streamPreviewLimit = 4000                    // Maximum number of characters shown while the answer is being generated
+	streamCursor       = " ▌"
+)
+
+type streamEditor struct {
+	bot      telebot.API
+	msg      *telebot.Message // Placeholder message that is edited
+	mu       sync.Mutex
+	text     strings.Builder // Everything received so far
+	shown    string          // Text of the last successful edit
+	lastEdit time.Time
+	holdOff  time.Time // Edits are paused until this moment after a flood error
+}
+
+func newStreamEditor(bot telebot.API, msg *telebot.Message) *streamEditor {
+	return &streamEditor{
+		bot:      bot,
+		msg:      msg,
+		lastEdit: time.Now(),
+	}
+}
+
+// Append adds a piece of the answer and edits the placeholder if enough time has passed since the previous edit.
+func (s *streamEditor) Append(delta string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.text.WriteString(delta)
+	now := time.Now()
+	if now.Sub(s.lastEdit) < streamEditInterval || now.Before(s.holdOff) {
+		return
+	}
+	preview, truncated := truncateRunes(strings.TrimSpace(s.text.String()), streamPreviewLimit)
+	if preview == "" || preview == s.shown {
+		return
+	}
+	if !truncated {
+		preview += streamCursor
+	}
+	s.lastEdit = now
+	if _, err := s.bot.Edit(s.msg, preview); err != nil {
+		var flood telebot.FloodError
+		if errors.As(err, &flood) {
+			s.holdOff = now.Add(time.Duration(flood.RetryAfter) * time.Second)
+		}
+		return
+	}
+	s.shown = preview
+}
+
+func truncateRunes(text string, limit int) (string, bool) { // Cuts the text to the limit without breaking multi-byte characters
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text, false
+	}
+	return string(runes[:limit]), true
+}
+
+func safeEdit(bot telebot.API, msg *telebot.Message, text string, opts ...interface{}) error { // safeEdit edits a message with retries, like safeSend
+	var lastErr error
+	for i := range 3 {
+		_, err := bot.Edit(msg, text, opts...)
+		if err == nil || errors.Is(err, telebot.ErrMessageNotModified) {
+			return nil
+		}
+		lastErr = err
+		if isEntityError(err) { // Repeating will not help, the caller decides what to send instead
+			return err
+		}
+		var flood telebot.FloodError
+		if errors.As(err, &flood) {
+			time.Sleep(time.Duration(flood.RetryAfter) * time.Second)
+			continue
+		}
+		time.Sleep(time.Second * time.Duration(i+1))
+	}
+	return lastErr
+}
+
+func (h *TelegramHandler) processMessage(c telebot.Context, text string) error {
+	// Text message processing logic
+	startTime := time.Now()
+	user := c.Sender()
+
+	h.Logger.Printf("Message from %d %s in %d: %.100s...", user.ID, user.Username, c.Chat().ID, text)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	dialog := h.dialogOf(ctx, c)
+	reply := replyOf(c.Message())
+	if reply.Text == text { // /ask in reply to a message asks the message itself
+		reply = ReplyTo{}
+	}
+	if !dialog.private() { // The model has to know who of the members is speaking
+		text = authorName(user) + ": " + text
+	}
+
+	if reply, exceeded := h.checkQuota(ctx, h.lang(c), user); exceeded {
+		return c.Send(reply)
+	}
+
+	if err := c.Notify(telebot.Typing); err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to send typing action %d %s: %v", user.ID, user.Username, err)
+	}
+
+	placeholder, err := h.sendPlaceholder(c, h.text(c, "answer.thinking")) // The answer is shown in this message while it is being generated
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to send placeholder to %d: %v", user.ID, err)
+		return c.Send(h.text(c, "answer.send_failed"))
+	}
+
+	ok, err := h.streamAnswer(c, placeholder, func(onDelta func(string)) (Answer, error) {
+		return h.Neural.HandleMessageStream(ctx, dialog, text, reply, onDelta) // Neural network response to user
+	})
+	if ok {
+		h.Logger.Printf("Successfully responded to %d in %v", user.ID, time.Since(startTime))
+	}
+	return err
+}
+
+// streamAnswer shows the answer produced by generate in msg while it is being generated and then puts the final text
+// with the answer buttons there. ok reports whether the answer was delivered.
+func (h *TelegramHandler) streamAnswer(c telebot.Context, msg *telebot.Message, generate func(onDelta func(string)) (Answer, error)) (ok bool, err error) {
+	user := c.Sender()
+	lang := h.lang(c)
+	editor := newStreamEditor(c.Bot(), msg)
+
+	answer, err := generate(editor.Append)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Error from Neural for user %d: %v", user.ID, err)
+		return false, safeEdit(c.Bot(), msg, errorReply(lang, err))
+	}
+
+	if answer.Text == "" { // Due to internal errors or other conditions, the neural network may send an empty response to the user
+		h.Logger.Printf("[ ERROR ] Empty response from Neural for user %d", user.ID)
+		return false, safeEdit(c.Bot(), msg, i18n.T(lang, "answer.empty"))
+	}
+
+	editPlaceholder := true
+	if answer.Reasoning != "" && h.showReasoning(user) { // The placeholder shows the reasoning, the answer follows in new messages
+		if err := safeEdit(c.Bot(), msg, reasoningQuote(answer.Reasoning), telebot.ModeHTML); err != nil {
+			h.Logger.Printf("[ ERROR ] Failed to show reasoning to %d: %v", user.ID, err)
+		} else {
+			editPlaceholder = false
+		}
+	}
+
+	text, files := extractCodeFiles(lang, answer.Text, h.CodeFileSize) // Large code blocks are unreadable in a chat, they are sent as files after the text
+	chunks := splitRendered(text, messageLimit)                        // Long answers are sent as several messages, the buttons go under the last one
+	markup := answerMarkup(lang, answer.MessageID)
+	sent := make([]int, 0, len(chunks)) // Telegram messages that show the answer, replies to them refer to it
+	for i, chunk := range chunks {
+		opts := []interface{}{}
+		if i == len(chunks)-1 && c.Message().Private() { // In groups the answer cannot be changed, it belongs to everybody
+			opts = append(opts, markup)
+		}
+		var part *telebot.Message
+		if i == 0 && editPlaceholder { // Replace the placeholder with the beginning of the answer
+			part, err = sendMarkdown(chunk, func(text string, opts ...interface{}) (*telebot.Message, error) {
+				return msg, safeEdit(c.Bot(), msg, text, opts...)
+			}, opts...)
+		} else {
+			part, err = sendMarkdown(chunk, func(text string, opts ...interface{}) (*telebot.Message, error) {
+				return safeSend(c, text, opts...)
+			}, opts...)
+		}
+		if err != nil {
+			h.Logger.Printf("[ ERROR ] Failed to send message part %d/%d to %d: %v", i+1, len(chunks), user.ID, err)
+			return false, c.Send(h.text(c, "answer.send_failed"))
+		}
+		sent = append(sent, part.ID)
+	}
+	if err := h.Neural.LinkMessages(context.Background(), c.Chat().ID, answer.MessageID, sent); err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to link answer %d to messages of %d: %v", answer.MessageID, user.ID, err)
+	}
+	for _, file := range files {
+		doc := &telebot.Document{File: telebot.FromReader(strings.NewReader(file.Content)), FileName: file.Name}
+		if err := c.Send(doc); err != nil {
+			h.Logger.Printf("[ ERROR ] Failed to send file %s to %d: %v", file.Name, user.ID, err)
+		}
+	}
+	return true, nil
+}
+
+func (h *TelegramHandler) showReasoning(user *telebot.User) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	settings, err := h.Neural.UserSettings(ctx, user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] User settings error for user %d %s: %v", user.ID, user.Username, err)
+	}
+	return settings.ShowReasoning
+}
+
+func reasoningQuote(reasoning string) string { // The reasoning in a collapsed quote, cut to fit into one message
+	parts := splitMessage(strings.TrimSpace(reasoning), messageLimit-100)
+	text := parts[0]
+	if len(parts) > 1 {
+		text += "\n…"
+	}
+	return "<blockquote expandable>💭 " + html.EscapeString(text) + "</blockquote>"
+}
+
+func answerMarkup(lang string, messageID int64) *telebot.ReplyMarkup { // Buttons under an answer, they refer to its row in chat_messages
+	markup := &telebot.ReplyMarkup{}
+	id := strconv.FormatInt(messageID, 10)
+	markup.Inline(markup.Row(
+		markup.Data(i18n.T(lang, "answer.regenerate"), regenerateButton, id),
+		markup.Data(i18n.T(lang, "answer.continue"), continueButton, id),
+	))
+	return markup
+}
+
+func (h *TelegramHandler) sendPlaceholder(c telebot.Context, text string) (*telebot.Message, error) { // In groups the placeholder replies to the question, so it is clear whom the bot answers
+	if c.Message().Private() {
+		return c.Bot().Send(c.Recipient(), text)
+	}
+	return c.Bot().Reply(c.Message(), text, &telebot.SendOptions{ThreadID: c.Message().ThreadID})
+}
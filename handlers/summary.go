@@ -0,0 +1,135 @@
+// Tasks: Condensing old turns of long dialogs into a summary that is sent instead of them.
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"quokka-ai-bot/models"
+	"quokka-ai-bot/utils"
+	"strings"
+	"time"
+)
+
+const summaryPrompt = `You maintain a running summary of a conversation between a user and an AI assistant.
+Combine the previous summary (if any) and the new messages into one concise summary.
+Keep facts about the user, their goals, decisions made, open questions and any details the assistant may need later.
+Write it in the language of the conversation, in plain text, no longer than 300 words.`
+
+const summaryMessageLimit = 4000 // Characters of one message passed to the summary, long pastes are cut
+
+type conversationSummary struct {
+	Content       string
+	LastMessageID int64 // Messages up to this ID are covered by the summary
+}
+
+func (s conversationSummary) message() models.Message { // The summary as it is prepended to the history
+	return models.Message{
+		Role:    "system",
+		Content: "Summary of the earlier part of this conversation:\n" + s.Content,
+	}
+}
+
+func (h *NeuralHandler) getSummary(ctx context.Context, conversationID int64) (conversationSummary, error) {
+	var summary conversationSummary
+	err := h.DB.QueryRowContext(ctx,
+		"SELECT content, last_message_id FROM chat_summaries WHERE conversation_id = $1",
+		conversationID).Scan(&summary.Content, &summary.LastMessageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return conversationSummary{}, nil
+	}
+	if err != nil {
+		return conversationSummary{}, err
+	}
+	summary.Content, err = utils.DecryptMessage(summary.Content)
+	if err != nil {
+		return conversationSummary{}, fmt.Errorf("decryption failed: %w", err)
+	}
+	return summary, nil
+}
+
+func (h *NeuralHandler) saveSummary(ctx context.Context, conversationID int64, summary conversationSummary) error {
+	aesContent, err := utils.EncryptMessage(summary.Content)
+	if err != nil {
+		return err
+	}
+	_, err = h.DB.ExecContext(ctx,
+		`INSERT INTO chat_summaries (conversation_id, content, last_message_id, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (conversation_id) DO UPDATE SET content = EXCLUDED.content, last_message_id = EXCLUDED.last_message_id, updated_at = EXCLUDED.updated_at`,
+		conversationID, aesContent, summary.LastMessageID, time.Now())
+	return err
+}
+
+// maintainInBackground gives the conversation a title and updates its summary unless this is already running.
+// The answer has been sent by then, so the user does not wait for it. Tokens are charged to userID.
+func (h *NeuralHandler) maintainInBackground(userID, conversationID int64) {
+	if _, busy := h.maintaining.LoadOrStore(conversationID, struct{}{}); busy {
+		return
+	}
+	go func() {
+		defer h.maintaining.Delete(conversationID)
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+		defer cancel()
+		if err := h.generateTitle(ctx, userID, conversationID); err != nil {
+			log.Printf("[ ERROR ] Title error for conversation %d: %v", conversationID, err)
+		}
+		if err := h.summarize(ctx, userID, conversationID); err != nil {
+			log.Printf("[ ERROR ] Summary error for conversation %d: %v", conversationID, err)
+		}
+	}()
+}
+
+// summarize condenses everything except the newest SummaryKeep messages into the stored summary
+// once more than SummaryAfter messages have accumulated after the previous one.
+func (h *NeuralHandler) summarize(ctx context.Context, userID, conversationID int64) error {
+	if h.SummaryAfter <= 0 {
+		return nil
+	}
+	summary, err := h.getSummary(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	history, err := h.getMessages(ctx, conversationID, summary.LastMessageID, h.HistoryLimit)
+	if err != nil {
+		return err
+	}
+	if len(history) <= h.SummaryAfter {
+		return nil
+	}
+
+	split := max(len(history)-h.SummaryKeep, 1)
+	for split < len(history)-1 && history[split].Role != "user" { // The kept part starts with a question
+		split++
+	}
+	old := history[:split]
+
+	var input strings.Builder
+	if summary.Content != "" {
+		fmt.Fprintf(&input, "Previous summary:\n%s\n\n", summary.Content)
+	}
+	input.WriteString("New messages:\n")
+	for _, msg := range old {
+		content, _ := truncateRunes(msg.Content, summaryMessageLimit)
+		fmt.Fprintf(&input, "%s: %s\n\n", msg.Role, content)
+	}
+
+	response, route, err := h.complete(ctx, models.ChatRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: summaryPrompt},
+			{Role: "user", Content: input.String()},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	content := strings.TrimSpace(response.Content)
+	if content == "" {
+		return errors.New("empty summary")
+	}
+	if err := h.saveSummary(ctx, conversationID, conversationSummary{Content: content, LastMessageID: old[len(old)-1].ID}); err != nil {
+		return err
+	}
+	return h.saveUsage(ctx, userID, 0, route.String(), response.Usage) // The summary is paid by the user whose dialog it condenses
+}
@@ -3,14 +3,29 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"quokka-ai-bot/config"
+	"quokka-ai-bot/models"
+	"regexp"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"gopkg.in/telebot.v4"
 )
 
+const ( // Unique parts of inline button data, they route callbacks to handlers
+	personaButton       = "persona"
+	chatButton          = "chat"
+	regenerateButton    = "regen"
+	continueButton      = "continue"
+	groupSettingsButton = "groupset"
+	settingsButton      = "settings"
+	languageButton      = "lang"
+)
+
 type TelegramHandler struct {
 	Bot      *telebot.Bot   // Telegram bot instance
 	Neural   *NeuralHandler // NeuralHandler instance for working with neural network
@@ -18,9 +33,17 @@ type TelegramHandler struct {
 	Redis    *redis.Client  // Redis database for storing message intervals
 	MsgDelay time.Duration
 	ComDelay time.Duration
+
+	CodeFileSize     int                // Code blocks longer than this many characters are sent as files, negative disables it
+	Transcriber      models.Transcriber // Speech recognition for voice messages, nil if it is not configured
+	MaxVoiceDuration int                // Seconds
+
+	mention      *regexp.Regexp // Matches @username of the bot, nil if the bot has no username
+	inlineLatest sync.Map       // User ID -> ID of their newest inline query
+	inlineJobs   sync.Map       // Cache key -> *inlineJob being generated
 }
 
-func NewTelegramhandler(bot *telebot.Bot, neural *NeuralHandler, logger *log.Logger, rdb *redis.Client) *TelegramHandler { // Constructor that initializes the Telegram handler
+func NewTelegramhandler(cfg *config.Config, bot *telebot.Bot, neural *NeuralHandler, logger *log.Logger, rdb *redis.Client, transcriber models.Transcriber) *TelegramHandler { // Constructor that initializes the Telegram handler
 	go func() {
 		for {
 			if err := neural.cleanUpOldMessages(context.Background(), 24*time.Hour); err != nil {
@@ -29,6 +52,10 @@ func NewTelegramhandler(bot *telebot.Bot, neural *NeuralHandler, logger *log.Log
 			time.Sleep(2 * time.Hour)
 		}
 	}() // Automatic database cleaning when messages are stored for more than X hours specified in the cleanUpMessages function
+	var mention *regexp.Regexp
+	if bot.Me != nil && bot.Me.Username != "" { // NewBot has asked Telegram who the bot is
+		mention = regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(bot.Me.Username) + `\b`)
+	}
 	return &TelegramHandler{
 		Bot:      bot,
 		Neural:   neural,
@@ -36,6 +63,12 @@ func NewTelegramhandler(bot *telebot.Bot, neural *NeuralHandler, logger *log.Log
 		Redis:    rdb,
 		MsgDelay: 1 * time.Minute,
 		ComDelay: 10 * time.Second,
+
+		CodeFileSize:     cfg.CodeFileSize,
+		Transcriber:      transcriber,
+		MaxVoiceDuration: cfg.Speech.MaxDuration,
+
+		mention: mention,
 	}
 }
 
@@ -46,8 +79,32 @@ func (h *TelegramHandler) RegisterHandlers() { // Registers command and message
 	h.Bot.Handle("/about", h.HandleAbout)
 	h.Bot.Handle("/policy", h.HandlePolicy)
 	h.Bot.Handle("/rules", h.HandleRules)
+	h.Bot.Handle("/limits", h.HandleLimits)
+	h.Bot.Handle("/persona", h.HandlePersona)
+	h.Bot.Handle("/reasoning", h.HandleReasoning)
+	h.Bot.Handle("/settings", h.HandleSettings)
+	h.Bot.Handle("/language", h.HandleLanguage)
+	h.Bot.Handle("/timezone", h.HandleTimezone)
+	h.Bot.Handle("/new", h.HandleNew)
+	h.Bot.Handle("/chats", h.HandleChats)
+	h.Bot.Handle("/ask", h.HandleAsk)
+	h.Bot.Handle("/groupsettings", h.HandleGroupSettings)
+	h.Bot.Handle("/switch", h.HandleSwitch)
+
+	h.Bot.Handle(&telebot.Btn{Unique: personaButton}, h.HandlePersonaCallback)
+	h.Bot.Handle(&telebot.Btn{Unique: chatButton}, h.HandleChatCallback)
+	h.Bot.Handle(&telebot.Btn{Unique: regenerateButton}, h.HandleRegenerateCallback)
+	h.Bot.Handle(&telebot.Btn{Unique: continueButton}, h.HandleContinueCallback)
+	h.Bot.Handle(&telebot.Btn{Unique: groupSettingsButton}, h.HandleGroupSettingsCallback)
+	h.Bot.Handle(&telebot.Btn{Unique: settingsButton}, h.HandleSettingsCallback)
+	h.Bot.Handle(&telebot.Btn{Unique: languageButton}, h.HandleLanguageCallback)
 
 	h.Bot.Handle(telebot.OnText, h.HandleText)
+	h.Bot.Handle(telebot.OnVoice, h.HandleVoice)
+	h.Bot.Handle(telebot.OnAudio, h.HandleVoice)
+	h.Bot.Handle(telebot.OnDocument, h.HandleDocument)
+	h.Bot.Handle(telebot.OnPhoto, h.HandlePhoto)
+	h.Bot.Handle(telebot.OnQuery, h.HandleQuery)
 }
 
 func (n *NeuralHandler) cleanUpOldMessages(ctx context.Context, olderThan time.Duration) error {
@@ -55,25 +112,43 @@ func (n *NeuralHandler) cleanUpOldMessages(ctx context.Context, olderThan time.D
 	_, err := n.DB.ExecContext(ctx, `
 		DELETE FROM chat_messages WHERE created_at < NOW() - $1::interval
 	`, interval) // Clear messages that are stored in the chat messages for more than <olderThan> time
+	if err != nil {
+		return err
+	}
+	_, err = n.DB.ExecContext(ctx, `
+		DELETE FROM conversations WHERE updated_at < NOW() - $1::interval
+	`, interval) // Conversations that have not been continued are cleared together with their summaries
 	return err
 }
 
-func safeSend(c telebot.Context, text string) error { // safeSend sends a message with panic handling and retries
+func safeSend(c telebot.Context, text string, opts ...interface{}) (msg *telebot.Message, err error) { // safeSend sends a message with panic handling and retries
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Recovered from panic while sending: %v", r)
+			err = fmt.Errorf("panic while sending: %v", r)
 		}
 	}()
+	if m := c.Message(); m != nil && m.ThreadID != 0 { // Like c.Send, stay in the topic of the message
+		opts = append(opts, &telebot.Topic{ThreadID: m.ThreadID})
+	}
 
 	// Try to send 3 times with a delay
 	var lastErr error
 	for i := range 3 {
-		if err := c.Send(text); err == nil {
-			return nil
+		if msg, err := c.Bot().Send(c.Recipient(), text, opts...); err == nil {
+			return msg, nil
 		} else {
 			lastErr = err
+			if isEntityError(err) { // Repeating will not help, the caller decides what to send instead
+				return nil, err
+			}
+			var flood telebot.FloodError
+			if errors.As(err, &flood) { // Telegram tells how long to wait
+				time.Sleep(time.Duration(flood.RetryAfter) * time.Second)
+				continue
+			}
 			time.Sleep(time.Second * time.Duration(i+1))
 		}
 	}
-	return lastErr // If the error still remains, return the last one
+	return nil, lastErr // If the error still remains, return the last one
 }
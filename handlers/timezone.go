@@ -0,0 +1,60 @@
+// Tasks: The time zone of every user, the date and time tool answers in it.
+package handlers
+
+import (
+	"context"
+	"html"
+	"quokka-ai-bot/i18n"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+const timezoneReset = "reset" // Argument of /timezone that goes back to the time zone from the config
+
+// messageTimezone sets the time zone given as the argument of /timezone, or shows the current one if there is no argument.
+func (h *TelegramHandler) messageTimezone(lang string, user *telebot.User, arg string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	settings, err := h.Neural.UserSettings(ctx, user.ID)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] User settings error for user %d %s: %v", user.ID, user.Username, err)
+		return i18n.T(lang, "settings.get_failed")
+	}
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return i18n.T(lang, "timezone.current", html.EscapeString(h.timezoneOf(settings)), h.timezoneNow(settings))
+	}
+	if strings.EqualFold(arg, timezoneReset) {
+		settings.Timezone = ""
+	} else {
+		location, err := time.LoadLocation(arg)
+		if err != nil || arg == "Local" { // Local is the time zone of the server, not a place
+			return i18n.T(lang, "timezone.unknown", html.EscapeString(arg))
+		}
+		settings.Timezone = location.String()
+	}
+	if err := h.Neural.SetUserSettings(ctx, user.ID, settings); err != nil {
+		h.Logger.Printf("[ ERROR ] User settings error for user %d %s: %v", user.ID, user.Username, err)
+		return i18n.T(lang, "settings.save_failed")
+	}
+	h.Logger.Printf("User %d %s set time zone %q", user.ID, user.Username, settings.Timezone)
+	return i18n.T(lang, "timezone.saved", html.EscapeString(h.timezoneOf(settings)), h.timezoneNow(settings))
+}
+
+func (h *TelegramHandler) timezoneOf(settings UserSettings) string { // The time zone of the user, the one from the config if none is set
+	if settings.Timezone != "" {
+		return settings.Timezone
+	}
+	return h.Neural.Location.String()
+}
+
+func (h *TelegramHandler) timezoneNow(settings UserSettings) string {
+	location, err := time.LoadLocation(h.timezoneOf(settings))
+	if err != nil {
+		location = h.Neural.Location
+	}
+	return time.Now().In(location).Format("2006-01-02 15:04")
+}
@@ -0,0 +1,68 @@
+// Tasks: Running the tools the model asks for and sending the results back until it answers.
+package handlers
+
+import (
+	"context"
+	"log"
+	"quokka-ai-bot/models"
+	"strings"
+)
+
+type completer func(ctx context.Context, request models.ChatRequest) (models.Completion, models.ModelRoute, error)
+
+// completeWithTools offers the registered tools with the request and runs the calls the model makes, until it answers with text.
+// After MaxToolIterations rounds of calls the tools are forbidden, so the model has to answer with what it has.
+// Calls and their results live only in the request, the history keeps the final answer. The usage and the reasoning of all rounds are summed.
+func (h *NeuralHandler) completeWithTools(ctx context.Context, request models.ChatRequest, complete completer) (models.Completion, models.ModelRoute, error) {
+	if h.Tools == nil {
+		return complete(ctx, request)
+	}
+	request.Tools = h.Tools.Specs()
+	request.Messages = append([]models.Message(nil), request.Messages...) // The caller's slice is not changed
+	var usage models.Usage
+	var reasoning []string
+	for round := 0; ; round++ {
+		if round >= h.MaxToolIterations {
+			request.ToolChoice = models.ToolChoiceNone
+		}
+		response, route, err := complete(ctx, request)
+		if err != nil {
+			return models.Completion{}, models.ModelRoute{}, err
+		}
+		usage.PromptTokens += response.Usage.PromptTokens
+		usage.CompletionTokens += response.Usage.CompletionTokens
+		usage.CachedTokens += response.Usage.CachedTokens
+		if response.Reasoning != "" {
+			reasoning = append(reasoning, response.Reasoning)
+		}
+		if len(response.ToolCalls) == 0 || request.ToolChoice == models.ToolChoiceNone {
+			response.ToolCalls = nil
+			response.Usage = usage
+			response.Reasoning = strings.Join(reasoning, "\n\n")
+			return response, route, nil
+		}
+
+		request.Messages = append(request.Messages, models.Message{Role: "assistant", Content: response.Content, ToolCalls: response.ToolCalls})
+		for _, call := range response.ToolCalls {
+			result := h.Tools.Call(ctx, call)
+			outcome := "ok"
+			if strings.HasPrefix(result, "error:") { // Arguments and results may contain what the user wrote, only the outcome is logged
+				outcome = "error"
+			}
+			log.Printf("Tool %s: %s", call.Function.Name, outcome)
+			request.Messages = append(request.Messages, models.Message{Role: "tool", ToolCallID: call.ID, Content: result})
+		}
+	}
+}
+
+func (h *NeuralHandler) completeWith(chain []models.ModelRoute) completer { // completeOn as a completer
+	return func(ctx context.Context, request models.ChatRequest) (models.Completion, models.ModelRoute, error) {
+		return h.completeOn(ctx, chain, request)
+	}
+}
+
+func (h *NeuralHandler) streamTo(chain []models.ModelRoute, onDelta func(delta string)) completer { // completeStreamOn as a completer, every round is shown as it comes
+	return func(ctx context.Context, request models.ChatRequest) (models.Completion, models.ModelRoute, error) {
+		return h.completeStreamOn(ctx, chain, request, onDelta)
+	}
+}
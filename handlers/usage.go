@@ -0,0 +1,70 @@
+// Tasks: Accounting of tokens spent by users.
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"quokka-ai-bot/models"
+	"time"
+)
+
+type DailyUsage struct { // Tokens spent by a user during one day (UTC)
+	Day              time.Time
+	PromptTokens     int64
+	CompletionTokens int64
+	CachedTokens     int64
+	Requests         int
+}
+
+func (h *NeuralHandler) saveUsage(ctx context.Context, userID, messageID int64, model string, usage models.Usage) error { // Stores usage of one answer and adds it to the daily total of the user
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO token_usage (message_id, user_id, model, prompt_tokens, completion_tokens, cached_tokens)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		sql.NullInt64{Int64: messageID, Valid: messageID != 0}, userID, model, usage.PromptTokens, usage.CompletionTokens, usage.CachedTokens)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO token_usage_daily (user_id, day, prompt_tokens, completion_tokens, cached_tokens, requests)
+		VALUES ($1, (NOW() AT TIME ZONE 'UTC')::date, $2, $3, $4, 1)
+		ON CONFLICT (user_id, day) DO UPDATE SET
+			prompt_tokens = token_usage_daily.prompt_tokens + EXCLUDED.prompt_tokens,
+			completion_tokens = token_usage_daily.completion_tokens + EXCLUDED.completion_tokens,
+			cached_tokens = token_usage_daily.cached_tokens + EXCLUDED.cached_tokens,
+			requests = token_usage_daily.requests + 1`,
+		userID, usage.PromptTokens, usage.CompletionTokens, usage.CachedTokens)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DailyUsage returns the usage of the user for every day starting from the given one, oldest first.
+func (h *NeuralHandler) DailyUsage(ctx context.Context, userID int64, since time.Time) ([]DailyUsage, error) {
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT day, prompt_tokens, completion_tokens, cached_tokens, requests
+		FROM token_usage_daily
+		WHERE user_id = $1 AND day >= $2
+		ORDER BY day`,
+		userID, since.UTC().Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []DailyUsage
+	for rows.Next() {
+		var d DailyUsage
+		if err := rows.Scan(&d.Day, &d.PromptTokens, &d.CompletionTokens, &d.CachedTokens, &d.Requests); err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
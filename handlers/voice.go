@@ -0,0 +1,98 @@
+// Tasks: Answering voice messages and audio files, they are turned into text and then handled like text messages.
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v4"
+)
+
+const (
+	voiceFileLimit  = 20 << 20 // Bytes, bots cannot download larger files from Telegram
+	transcriptLimit = 1000     // Characters of the recognized text shown to the user
+)
+
+func (h *TelegramHandler) HandleVoice(c telebot.Context) error {
+	user := c.Sender()
+	msg := c.Message()
+	if !msg.Private() {
+		if (h.mention == nil || !h.mention.MatchString(msg.Caption)) && !h.groupAddressed(c) { // In groups the bot answers only when it is addressed
+			return nil
+		}
+	}
+	if h.Transcriber == nil {
+		return c.Send(h.text(c, "voice.unsupported"))
+	}
+
+	file, fileName, duration := voiceFile(msg)
+	if file == nil {
+		return nil
+	}
+	if h.MaxVoiceDuration > 0 && duration > h.MaxVoiceDuration {
+		return c.Send(h.text(c, "voice.too_long", h.MaxVoiceDuration))
+	}
+	if file.FileSize > voiceFileLimit {
+		return c.Send(h.text(c, "voice.too_large"))
+	}
+
+	allowed, waitTime, err := h.checkRateLimitMessage(user.ID) // Recognition is not free either, so it is limited before the answer
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Redis error for user %d %s: %v", user.ID, user.Username, err)
+	} else if !allowed {
+		h.Logger.Printf("Rate limit for user %d %s (wait %.1fs)", user.ID, user.Username, waitTime.Seconds())
+		return c.Send(h.text(c, "wait.request", waitTime.Seconds()))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+	if reply, exceeded := h.checkQuota(ctx, h.lang(c), user); exceeded {
+		return c.Send(reply)
+	}
+	if err := c.Notify(telebot.Typing); err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to send typing action %d %s: %v", user.ID, user.Username, err)
+	}
+
+	h.Logger.Printf("Voice message from %d %s in %d (%d s)", user.ID, user.Username, c.Chat().ID, duration)
+	text, err := h.transcribe(ctx, file, fileName)
+	if err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to transcribe voice of %d: %v", user.ID, err)
+		return c.Send(h.text(c, "voice.failed"))
+	}
+	if text == "" {
+		return c.Send(h.text(c, "voice.no_speech"))
+	}
+
+	shown, _ := truncateRunes(text, transcriptLimit) // The user sees what the bot heard before the answer
+	if _, err := c.Bot().Reply(msg, "🎙 <i>"+html.EscapeString(shown)+"</i>", &telebot.SendOptions{ThreadID: msg.ThreadID, ParseMode: telebot.ModeHTML}); err != nil {
+		h.Logger.Printf("[ ERROR ] Failed to send transcript to %d: %v", user.ID, err)
+	}
+	return h.processMessage(c, text)
+}
+
+func voiceFile(msg *telebot.Message) (file *telebot.File, fileName string, duration int) { // The file of a voice message or an audio file, nil for other messages
+	switch {
+	case msg.Voice != nil:
+		return &msg.Voice.File, "voice.ogg", msg.Voice.Duration
+	case msg.Audio != nil:
+		fileName = msg.Audio.FileName
+		if fileName == "" {
+			fileName = "audio.mp3"
+		}
+		return &msg.Audio.File, fileName, msg.Audio.Duration
+	}
+	return nil, "", 0
+}
+
+func (h *TelegramHandler) transcribe(ctx context.Context, file *telebot.File, fileName string) (string, error) {
+	reader, err := h.Bot.File(file)
+	if err != nil {
+		return "", fmt.Errorf("error downloading file: %w", err)
+	}
+	defer reader.Close()
+	text, err := h.Transcriber.Transcribe(ctx, reader, fileName)
+	return strings.TrimSpace(text), err
+}
@@ -0,0 +1,109 @@
+// Tasks: Texts of the bot in every supported language and the choice of the language for a user.
+package i18n
+
+import (
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	Default  = "ru" // Texts missing in other catalogs are taken from it, users without a language code get it too
+	Fallback = "en" // Users whose language has no catalog get English, it is understood more widely than Russian
+)
+
+//go:embed locales/*.yaml
+var files embed.FS
+
+var catalogs = mustLoad() // Language code -> flattened key -> text
+
+func mustLoad() map[string]map[string]string { // The catalogs are built into the binary, a broken one is a bug
+	entries, err := files.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	result := make(map[string]map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := files.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var tree map[string]any
+		if err := yaml.Unmarshal(data, &tree); err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", entry.Name(), err))
+		}
+		catalog := make(map[string]string)
+		flatten("", tree, catalog)
+		result[strings.TrimSuffix(entry.Name(), path.Ext(entry.Name()))] = catalog
+	}
+	for lang, catalog := range result { // Missing texts still work through the default catalog, but should be translated
+		for key := range result[Default] {
+			if _, ok := catalog[key]; !ok {
+				log.Printf("[ WARN ] i18n: %s has no text for %s", lang, key)
+			}
+		}
+	}
+	return result
+}
+
+func flatten(prefix string, tree map[string]any, out map[string]string) { // Nested keys become dotted: settings: {title: ...} -> settings.title
+	for key, value := range tree {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			flatten(key, v, out)
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// Languages returns the codes of all catalogs, sorted.
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Match chooses the catalog for a Telegram language code such as "en" or "pt-br".
+func Match(code string) string {
+	if code == "" {
+		return Default
+	}
+	lang, _, _ := strings.Cut(strings.ToLower(code), "-")
+	if Supported(lang) {
+		return lang
+	}
+	return Fallback
+}
+
+// T returns the text of key in lang, formatted with args if there are any. A text missing in lang is taken
+// from the default catalog, a missing key is returned as is, so it shows up in the chat instead of an empty message.
+func T(lang, key string, args ...any) string {
+	text, ok := catalogs[lang][key]
+	if !ok {
+		if text, ok = catalogs[Default][key]; !ok {
+			log.Printf("[ WARN ] i18n: unknown key %s", key)
+			return key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
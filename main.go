@@ -6,6 +6,7 @@ import (
 	"quokka-ai-bot/config"
 	"quokka-ai-bot/handlers"
 	"quokka-ai-bot/migrator"
+	"quokka-ai-bot/models"
 	"quokka-ai-bot/utils"
 	"time"
 
@@ -36,8 +37,22 @@ func main() {
 		DB:       0,
 	})
 
-	neuralHandler := handlers.NewNeuralHandler(cfg.DeepSeekToken, db) // install neural network handler
-	botSettings := telebot.Settings{                                  // telebot settings
+	providers := models.NewProviders(cfg) // clients of the neural network APIs, shared by the chains below
+	chain, err := providers.ModelChain()  // neural network backends selected in the config: main provider and fallbacks
+	if err != nil {
+		logger.Fatalf("Failed to create provider: %v", err)
+	}
+	vision, err := providers.VisionChain() // models that answer photos, nil if none are configured
+	if err != nil {
+		logger.Fatalf("Failed to create vision provider: %v", err)
+	}
+	transcriber, err := models.NewTranscriber(cfg) // speech recognition for voice messages, nil if disabled
+	if err != nil {
+		logger.Fatalf("Failed to create transcriber: %v", err)
+	}
+	neuralHandler := handlers.NewNeuralHandler(cfg, chain, vision, db) // install neural network handler
+
+	botSettings := telebot.Settings{ // telebot settings
 		Token: cfg.TgToken,
 		Poller: &telebot.LongPoller{
 			Timeout: 10 * time.Second,
@@ -47,7 +62,7 @@ func main() {
 	if err != nil {
 		logger.Fatalf("Failed to create bot: %v", err)
 	}
-	tgHandler := handlers.NewTelegramhandler(bot, neuralHandler, logger, redisClient)
+	tgHandler := handlers.NewTelegramhandler(cfg, bot, neuralHandler, logger, redisClient, transcriber)
 	tgHandler.RegisterHandlers()
 
 	logger.Println("Starting bot...")
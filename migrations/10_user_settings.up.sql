@@ -0,0 +1,6 @@
+-- Preferences of a user, a missing row means the defaults
+CREATE TABLE user_settings (
+    user_id BIGINT PRIMARY KEY,
+    show_reasoning BOOLEAN NOT NULL DEFAULT FALSE, -- Show the thinking of reasoning models above the answer
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,6 @@
+ALTER TABLE user_settings
+    DROP COLUMN model,
+    DROP COLUMN temperature,
+    DROP COLUMN answer_length,
+    DROP COLUMN language,
+    DROP COLUMN response_format;
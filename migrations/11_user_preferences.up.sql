@@ -0,0 +1,7 @@
+-- Answer preferences chosen in /settings, an empty value means the default option
+ALTER TABLE user_settings
+    ADD COLUMN model TEXT NOT NULL DEFAULT '', -- Route of the model asked first, such as deepseek/deepseek-chat
+    ADD COLUMN temperature TEXT NOT NULL DEFAULT '', -- IDs of options defined in handlers/settings.go
+    ADD COLUMN answer_length TEXT NOT NULL DEFAULT '',
+    ADD COLUMN language TEXT NOT NULL DEFAULT '',
+    ADD COLUMN response_format TEXT NOT NULL DEFAULT '';
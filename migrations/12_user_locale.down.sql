@@ -0,0 +1 @@
+ALTER TABLE user_settings DROP COLUMN locale;
@@ -0,0 +1,2 @@
+-- Interface language chosen with /language, empty follows the language of the Telegram client
+ALTER TABLE user_settings ADD COLUMN locale TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- IANA time zone chosen with /timezone, empty means the time zone from the config
+ALTER TABLE user_settings ADD COLUMN timezone TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE chat_messages ADD COLUMN model TEXT;
+-- Provider and model that wrote an assistant answer, NULL for user messages
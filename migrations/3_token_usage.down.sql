@@ -0,0 +1,2 @@
+DROP TABLE token_usage_daily;
+DROP TABLE token_usage;
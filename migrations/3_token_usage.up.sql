@@ -0,0 +1,23 @@
+CREATE TABLE token_usage (
+    id SERIAL PRIMARY KEY,
+    message_id INT REFERENCES chat_messages(id) ON DELETE SET NULL, -- The message itself is cleaned up, the usage is kept
+    user_id BIGINT NOT NULL,
+    model TEXT NOT NULL,
+    prompt_tokens INT NOT NULL,
+    completion_tokens INT NOT NULL,
+    cached_tokens INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_token_usage_user_id ON token_usage(user_id);
+
+CREATE TABLE token_usage_daily (
+    user_id BIGINT NOT NULL,
+    day DATE NOT NULL, -- UTC date
+    prompt_tokens BIGINT NOT NULL DEFAULT 0,
+    completion_tokens BIGINT NOT NULL DEFAULT 0,
+    cached_tokens BIGINT NOT NULL DEFAULT 0,
+    requests INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, day)
+);
+-- Totals per user per day, updated together with token_usage
@@ -0,0 +1,6 @@
+CREATE TABLE chat_summaries (
+    user_id BIGINT PRIMARY KEY,
+    content TEXT NOT NULL, -- Encrypted like chat_messages.content
+    last_message_id INT NOT NULL, -- Messages up to this ID are covered by the summary
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
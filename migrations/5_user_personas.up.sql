@@ -0,0 +1,5 @@
+CREATE TABLE user_personas (
+    user_id BIGINT PRIMARY KEY,
+    persona TEXT NOT NULL, -- ID of a persona defined in handlers/personas.go
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,11 @@
+-- Only one dialog per user is possible without conversations, so summaries are dropped
+DELETE FROM chat_summaries;
+ALTER TABLE chat_summaries DROP CONSTRAINT chat_summaries_pkey;
+ALTER TABLE chat_summaries DROP COLUMN conversation_id;
+ALTER TABLE chat_summaries ADD COLUMN user_id BIGINT PRIMARY KEY;
+
+DROP INDEX idx_chat_messages_conversation_id;
+ALTER TABLE chat_messages DROP COLUMN conversation_id;
+
+DROP TABLE active_conversations;
+DROP TABLE conversations;
@@ -0,0 +1,35 @@
+CREATE TABLE conversations (
+    id SERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    title TEXT, -- Encrypted, NULL until it is generated
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_conversations_user_id ON conversations(user_id);
+
+CREATE TABLE active_conversations (
+    user_id BIGINT PRIMARY KEY,
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE
+);
+
+-- The existing dialog of every user becomes their first conversation
+INSERT INTO conversations (user_id, created_at, updated_at)
+SELECT user_id, MIN(created_at), MAX(created_at) FROM chat_messages GROUP BY user_id;
+
+INSERT INTO active_conversations (user_id, conversation_id)
+SELECT user_id, id FROM conversations;
+
+ALTER TABLE chat_messages ADD COLUMN conversation_id INT REFERENCES conversations(id) ON DELETE CASCADE;
+UPDATE chat_messages m SET conversation_id = c.id FROM conversations c WHERE c.user_id = m.user_id;
+ALTER TABLE chat_messages ALTER COLUMN conversation_id SET NOT NULL;
+
+CREATE INDEX idx_chat_messages_conversation_id ON chat_messages(conversation_id);
+
+-- Summaries belong to conversations now
+ALTER TABLE chat_summaries ADD COLUMN conversation_id INT REFERENCES conversations(id) ON DELETE CASCADE;
+UPDATE chat_summaries s SET conversation_id = c.id FROM conversations c WHERE c.user_id = s.user_id;
+DELETE FROM chat_summaries WHERE conversation_id IS NULL;
+ALTER TABLE chat_summaries DROP CONSTRAINT chat_summaries_pkey;
+ALTER TABLE chat_summaries DROP COLUMN user_id;
+ALTER TABLE chat_summaries ADD PRIMARY KEY (conversation_id);
@@ -0,0 +1,5 @@
+DROP TABLE group_settings;
+
+DELETE FROM conversations WHERE user_id < 0;
+DROP INDEX idx_conversations_group;
+ALTER TABLE conversations DROP COLUMN thread_id;
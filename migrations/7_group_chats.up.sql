@@ -0,0 +1,12 @@
+-- Conversations of group chats belong to the chat: user_id holds the (negative) chat ID,
+-- thread_id is the forum topic when topics keep separate histories and 0 otherwise
+ALTER TABLE conversations ADD COLUMN thread_id INT NOT NULL DEFAULT 0;
+
+CREATE UNIQUE INDEX idx_conversations_group ON conversations(user_id, thread_id) WHERE user_id < 0;
+
+CREATE TABLE group_settings (
+    chat_id BIGINT PRIMARY KEY,
+    respond_all BOOLEAN NOT NULL DEFAULT FALSE, -- Answer every message, not only mentions, replies and /ask
+    per_thread BOOLEAN NOT NULL DEFAULT TRUE, -- Separate history for every forum topic
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
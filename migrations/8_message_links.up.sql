@@ -0,0 +1,9 @@
+-- Telegram messages that show a stored message, so a reply to them can be traced back to the full text
+CREATE TABLE message_links (
+    chat_id BIGINT NOT NULL,
+    tg_message_id BIGINT NOT NULL,
+    message_id INT NOT NULL REFERENCES chat_messages(id) ON DELETE CASCADE,
+    PRIMARY KEY (chat_id, tg_message_id)
+);
+
+CREATE INDEX idx_message_links_message_id ON message_links(message_id);
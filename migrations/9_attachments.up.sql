@@ -0,0 +1,12 @@
+-- Files attached to a conversation, their text is sent with every request of it
+CREATE TABLE conversation_attachments (
+    id SERIAL PRIMARY KEY,
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL, -- Who attached the file
+    file_name TEXT NOT NULL, -- Encrypted
+    content TEXT NOT NULL, -- Encrypted extracted text
+    tokens INT NOT NULL, -- Estimated size of the content
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_conversation_attachments_conversation_id ON conversation_attachments(conversation_id);
@@ -0,0 +1,157 @@
+// Tasks: Failing fast while a provider is down instead of waiting for every request to time out.
+package models
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("service temporarily unavailable: circuit breaker is open")
+
+type BreakerSettings struct {
+	FailureRatio float64       // Share of failed requests in the window that opens the circuit
+	MinRequests  int           // Requests needed in the window before the ratio is checked
+	Window       time.Duration // Period over which requests are counted
+	OpenTimeout  time.Duration // How long the circuit stays open before probe requests are let through
+	Probes       int           // Successful probe requests needed to close the circuit again
+}
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // Requests pass, failures are counted
+	breakerOpen                         // Requests fail immediately with ErrCircuitOpen
+	breakerHalfOpen                     // A limited number of probe requests pass
+)
+
+type outcome int
+
+const ( // What a finished request says about the provider
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeIgnored // Cancelled by the caller or rejected as a bad request: the provider's health is unknown
+)
+
+// CircuitBreaker wraps a provider and stops sending requests to it after too many failures.
+// After OpenTimeout it lets a few probe requests through and closes again if they succeed.
+type CircuitBreaker struct {
+	ChatProvider
+	settings BreakerSettings
+
+	mu          sync.Mutex
+	state       breakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int // Probe requests currently in flight
+	successes   int // Successful probes since the circuit became half-open
+}
+
+func NewCircuitBreaker(provider ChatProvider, settings BreakerSettings) *CircuitBreaker {
+	if settings.Probes < 1 {
+		settings.Probes = 1
+	}
+	return &CircuitBreaker{
+		ChatProvider: provider,
+		settings:     settings,
+		windowStart:  time.Now(),
+	}
+}
+
+func (b *CircuitBreaker) ChatCompletion(ctx context.Context, req ChatRequest) (Completion, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return Completion{}, err
+	}
+	response, err := b.ChatProvider.ChatCompletion(ctx, req)
+	b.record(probe, outcomeOf(ctx, err))
+	return response, err
+}
+
+func (b *CircuitBreaker) ChatCompletionStream(ctx context.Context, req ChatRequest, onDelta func(delta string)) (Completion, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return Completion{}, err
+	}
+	response, err := b.ChatProvider.ChatCompletionStream(ctx, req, onDelta)
+	b.record(probe, outcomeOf(ctx, err))
+	return response, err
+}
+
+func (b *CircuitBreaker) allow() (probe bool, err error) { // Decides whether a request may be sent, probe is true for half-open requests
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.settings.OpenTimeout {
+			return false, ErrCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		b.probes, b.successes = 0, 0
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes >= b.settings.Probes-b.successes {
+			return false, ErrCircuitOpen
+		}
+		b.probes++
+		return true, nil
+	}
+	if now.Sub(b.windowStart) > b.settings.Window { // Start counting anew
+		b.windowStart = now
+		b.requests, b.failures = 0, 0
+	}
+	return false, nil
+}
+
+func (b *CircuitBreaker) record(probe bool, result outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probes-- // An ignored probe only frees its slot for the next one
+		if b.state != breakerHalfOpen || result == outcomeIgnored {
+			return
+		}
+		if result == outcomeFailure {
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.settings.Probes {
+			b.state = breakerClosed
+			b.windowStart = time.Now()
+			b.requests, b.failures = 0, 0
+		}
+		return
+	}
+	if b.state != breakerClosed || result == outcomeIgnored {
+		return
+	}
+	b.requests++
+	if result == outcomeFailure {
+		b.failures++
+	}
+	if b.requests >= b.settings.MinRequests && float64(b.failures)/float64(b.requests) >= b.settings.FailureRatio {
+		b.open()
+	}
+}
+
+func (b *CircuitBreaker) open() {
+	b.state = breakerOpen
+	b.openedAt = time.Now()
+}
+
+func outcomeOf(ctx context.Context, err error) outcome { // Only failures of the provider itself count, not bad requests
+	if err == nil {
+		return outcomeSuccess
+	}
+	if errors.Is(context.Cause(ctx), context.DeadlineExceeded) || IsRetryable(err) { // The provider did not answer in time or broke down
+		return outcomeFailure
+	}
+	return outcomeIgnored
+}
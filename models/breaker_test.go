@@ -0,0 +1,111 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeProvider struct {
+	err error // Returned by the next call
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) ChatCompletion(ctx context.Context, req ChatRequest) (Completion, error) {
+	return Completion{}, p.err
+}
+
+func (p *fakeProvider) ChatCompletionStream(ctx context.Context, req ChatRequest, onDelta func(delta string)) (Completion, error) {
+	return Completion{}, p.err
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	serverErr := &APIError{StatusCode: 503, Kind: ErrServerError}
+	badRequest := &APIError{StatusCode: 400, Kind: ErrBadRequest}
+	type step struct {
+		err       error        // What the provider returns
+		wait      bool         // Let OpenTimeout pass before the call
+		cancelled bool         // The caller gives up on the request
+		open      bool         // The call is expected to be rejected with ErrCircuitOpen
+		state     breakerState // State after the call
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"closed while failures are rare", []step{
+			{err: serverErr}, {}, {}, {err: serverErr}, {}, {state: breakerClosed},
+		}},
+		{"opens at the failure ratio", []step{
+			{err: serverErr}, {}, {err: serverErr}, {}, {err: serverErr, state: breakerOpen},
+			{open: true, state: breakerOpen},
+		}},
+		{"bad requests do not open it", []step{
+			{err: badRequest}, {err: badRequest}, {err: badRequest}, {err: badRequest}, {err: badRequest, state: breakerClosed},
+		}},
+		{"half-open closes after successful probes", []step{
+			{err: serverErr}, {err: serverErr}, {err: serverErr}, {err: serverErr}, {err: serverErr, state: breakerOpen},
+			{wait: true, state: breakerHalfOpen}, {state: breakerClosed}, {state: breakerClosed},
+		}},
+		{"failed probe opens it again", []step{
+			{err: serverErr}, {err: serverErr}, {err: serverErr}, {err: serverErr}, {err: serverErr, state: breakerOpen},
+			{wait: true, err: serverErr, state: breakerOpen}, {open: true, state: breakerOpen},
+		}},
+		{"cancelled and rejected probes are not successes", []step{
+			{err: serverErr}, {err: serverErr}, {err: serverErr}, {err: serverErr}, {err: serverErr, state: breakerOpen},
+			{wait: true, cancelled: true, err: context.Canceled, state: breakerHalfOpen},
+			{err: badRequest, state: breakerHalfOpen},
+			{state: breakerHalfOpen}, {state: breakerClosed},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeProvider{}
+			b := NewCircuitBreaker(provider, BreakerSettings{
+				FailureRatio: 0.5,
+				MinRequests:  5,
+				Window:       time.Minute,
+				OpenTimeout:  10 * time.Millisecond,
+				Probes:       2,
+			})
+			for i, s := range tt.steps {
+				if s.wait {
+					time.Sleep(15 * time.Millisecond)
+				}
+				ctx, cancel := context.WithCancel(context.Background())
+				if s.cancelled {
+					cancel()
+				}
+				provider.err = s.err
+				_, err := b.ChatCompletion(ctx, ChatRequest{})
+				cancel()
+				if got := errors.Is(err, ErrCircuitOpen); got != s.open {
+					t.Fatalf("step %d: rejected = %v, want %v (err %v)", i+1, got, s.open, err)
+				}
+				if b.state != s.state {
+					t.Fatalf("step %d: state %d, want %d", i+1, b.state, s.state)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerLimitsProbes(t *testing.T) {
+	b := NewCircuitBreaker(&fakeProvider{}, BreakerSettings{FailureRatio: 0.5, MinRequests: 1, Window: time.Minute, OpenTimeout: time.Millisecond, Probes: 2})
+	b.open()
+	time.Sleep(2 * time.Millisecond)
+	for i := range 2 {
+		if probe, err := b.allow(); !probe || err != nil {
+			t.Fatalf("probe %d: allow() = %v, %v, want a probe", i+1, probe, err)
+		}
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("third request while two probes are in flight: %v, want ErrCircuitOpen", err)
+	}
+	b.record(true, outcomeIgnored) // An ignored probe frees its slot
+	if probe, err := b.allow(); !probe || err != nil {
+		t.Fatalf("allow() after an ignored probe = %v, %v, want a probe", probe, err)
+	}
+}
@@ -0,0 +1,107 @@
+// Tasks: Errors returned by providers and their classification.
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ( // Classes of provider failures, check them with errors.Is
+	ErrRateLimited   = errors.New("rate limited")
+	ErrServerError   = errors.New("server error")
+	ErrAuthFailed    = errors.New("authentication failed")
+	ErrContextLength = errors.New("context length exceeded")
+	ErrBadRequest    = errors.New("bad request")
+)
+
+type APIError struct { // The provider answered with a non-200 status
+	StatusCode int
+	Body       string
+	Kind       error         // One of the Err* classes above
+	RetryAfter time.Duration // Value of the Retry-After header, zero if absent
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api returned status %d (%v): %s", e.StatusCode, e.Kind, e.Body)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		Kind:       classifyStatus(resp.StatusCode, string(body)),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+func classifyStatus(status int, body string) error {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case status >= 500:
+		return ErrServerError
+	case status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusPaymentRequired:
+		return ErrAuthFailed // 402 is how DeepSeek reports an empty balance, it needs the operator just like a bad key
+	case status == http.StatusRequestEntityTooLarge || isContextLengthMessage(body):
+		return ErrContextLength
+	}
+	return ErrBadRequest
+}
+
+func isContextLengthMessage(body string) bool { // Providers report an overflow as a plain 400 with different wording
+	body = strings.ToLower(body)
+	for _, marker := range []string{"context_length_exceeded", "context length", "maximum context", "context window", "too many tokens"} {
+		if strings.Contains(body, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRetryAfter(value string) time.Duration { // Retry-After is either a number of seconds or an HTTP date
+	if value == "" {
+		return 0
+	}
+	var seconds int
+	if _, err := fmt.Sscanf(value, "%d", &seconds); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// IsRetryable reports whether the request may succeed if repeated or sent to another model:
+// rate limits, server errors, timeouts, dropped connections and answers cut off midway.
+// Other network errors, such as an unknown host or a bad certificate, will not go away on a repeat.
+func IsRetryable(err error) bool {
+	if errors.Is(err, ErrRateLimited) || errors.Is(err, ErrServerError) {
+		return true
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error // Every error of http.Client.Do is a net.Error through *url.Error, only timeouts are transient
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF)
+}
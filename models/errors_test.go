@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   error
+	}{
+		{http.StatusTooManyRequests, "", ErrRateLimited},
+		{http.StatusInternalServerError, "", ErrServerError},
+		{http.StatusServiceUnavailable, "", ErrServerError},
+		{http.StatusUnauthorized, "", ErrAuthFailed},
+		{http.StatusForbidden, "", ErrAuthFailed},
+		{http.StatusPaymentRequired, "Insufficient Balance", ErrAuthFailed},
+		{http.StatusRequestEntityTooLarge, "", ErrContextLength},
+		{http.StatusBadRequest, `{"error":{"code":"context_length_exceeded"}}`, ErrContextLength},
+		{http.StatusBadRequest, "This model's Maximum Context length is 65536 tokens", ErrContextLength},
+		{http.StatusBadRequest, `{"error":"invalid temperature"}`, ErrBadRequest},
+		{http.StatusNotFound, "", ErrBadRequest},
+	}
+	for _, tt := range tests {
+		if got := classifyStatus(tt.status, tt.body); got != tt.want {
+			t.Errorf("classifyStatus(%d, %q) = %v, want %v", tt.status, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		min, max time.Duration
+	}{
+		{"empty", "", 0, 0},
+		{"seconds", "7", 7 * time.Second, 7 * time.Second},
+		{"zero seconds", "0", 0, 0},
+		{"negative seconds", "-3", 0, 0},
+		{"http date", time.Now().Add(20 * time.Second).UTC().Format(http.TimeFormat), 18 * time.Second, 20 * time.Second},
+		{"date in the past", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+		{"garbage", "soon", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+				t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	urlErr := func(err error) error { return &url.Error{Op: "Post", URL: "https://api.example.com", Err: err} }
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limit", &APIError{StatusCode: 429, Kind: ErrRateLimited}, true},
+		{"server error", fmt.Errorf("wrapped: %w", &APIError{StatusCode: 502, Kind: ErrServerError}), true},
+		{"bad request", &APIError{StatusCode: 400, Kind: ErrBadRequest}, false},
+		{"auth", &APIError{StatusCode: 401, Kind: ErrAuthFailed}, false},
+		{"context length", &APIError{StatusCode: 400, Kind: ErrContextLength}, false},
+		{"cancelled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, true},
+		{"client timeout", urlErr(timeoutError{}), true},
+		{"connection reset", urlErr(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"connection refused", urlErr(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), true},
+		{"cut off answer", fmt.Errorf("stream ended: %w", io.ErrUnexpectedEOF), true},
+		{"unknown host", urlErr(&net.DNSError{Err: "no such host", Name: "api.example.com"}), false},
+		{"other request error", urlErr(errors.New("unsupported protocol scheme")), false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("%s: IsRetryable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
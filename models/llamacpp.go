@@ -0,0 +1,19 @@
+// Tasks: Sending requests to a local llama.cpp server (llama-server).
+package models
+
+type LlamaCppClient struct {
+	*OpenAIClient // llama-server exposes an OpenAI compatible /v1/chat/completions endpoint
+}
+
+const llamaCppBaseURL = "http://localhost:8080/v1"
+
+// NewLlamaCppClient creates a client for llama-server. The server answers with the model it was started with,
+// so the model name is optional, as is the API key (only needed if the server runs with --api-key).
+func NewLlamaCppClient(apiKey, baseURL, model string) *LlamaCppClient {
+	if baseURL == "" {
+		baseURL = llamaCppBaseURL
+	}
+	return &LlamaCppClient{
+		OpenAIClient: NewOpenAIClient("llamacpp", apiKey, baseURL, model),
+	}
+}
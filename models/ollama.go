@@ -0,0 +1,237 @@
+// Tasks: Sending requests to the native Ollama chat API.
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []ToolSpec      `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	Options  map[string]any  `json:"options,omitempty"`
+}
+
+type ollamaMessage struct { // Ollama takes images as a list of base64 strings next to the text
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	Thinking  string           `json:"thinking,omitempty"` // Reasoning of thinking models, only in responses
+}
+
+type ollamaToolCall struct { // Unlike OpenAI, calls have no ID and the arguments are an object, not a string
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+func fromOllamaToolCalls(calls []ollamaToolCall) []ToolCall {
+	var result []ToolCall
+	for i, call := range calls {
+		result = append(result, ToolCall{
+			ID:       fmt.Sprintf("call_%d", i),
+			Type:     "function",
+			Function: FunctionCall{Name: call.Function.Name, Arguments: string(call.Function.Arguments)},
+		})
+	}
+	return result
+}
+
+func toOllamaMessages(messages []Message) []ollamaMessage {
+	result := make([]ollamaMessage, 0, len(messages))
+	for _, msg := range messages {
+		om := ollamaMessage{Role: msg.Role, Content: msg.Content}
+		if len(msg.Parts) > 0 {
+			var text []string
+			for _, part := range msg.Parts {
+				switch {
+				case part.ImageURL != nil:
+					_, data, _ := strings.Cut(part.ImageURL.URL, ",") // Drop the "data:image/jpeg;base64," prefix
+					om.Images = append(om.Images, data)
+				case part.Text != "":
+					text = append(text, part.Text)
+				}
+			}
+			om.Content = strings.Join(text, "\n\n")
+		}
+		for _, call := range msg.ToolCalls {
+			var oc ollamaToolCall
+			oc.Function.Name = call.Function.Name
+			oc.Function.Arguments = json.RawMessage(call.Function.Arguments)
+			if !json.Valid(oc.Function.Arguments) {
+				oc.Function.Arguments = json.RawMessage("{}")
+			}
+			om.ToolCalls = append(om.ToolCalls, oc)
+		}
+		result = append(result, om)
+	}
+	return result
+}
+
+type ollamaResponse struct { // Ollama returns one such object, or one per line when streaming
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count"` // Token counts are sent with the final object
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+func (r ollamaResponse) usage() Usage {
+	return Usage{PromptTokens: r.PromptEvalCount, CompletionTokens: r.EvalCount}
+}
+
+type OllamaClient struct {
+	HTTPClient *http.Client
+	BaseURL    string // URL without /api/chat
+	Model      string // Model used when the request does not specify one
+	Retry      RetryPolicy
+}
+
+const ollamaBaseURL = "http://localhost:11434"
+
+func NewOllamaClient(baseURL, model string) *OllamaClient {
+	if baseURL == "" {
+		baseURL = ollamaBaseURL
+	}
+	return &OllamaClient{
+		HTTPClient: &http.Client{
+			Timeout: requestTimeout,
+		},
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Model:   model,
+		Retry:   DefaultRetryPolicy,
+	}
+}
+
+func (c *OllamaClient) Name() string {
+	return "ollama"
+}
+
+func (c *OllamaClient) ChatCompletion(ctx context.Context, req ChatRequest) (Completion, error) {
+	resp, err := c.post(ctx, req, false)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+	var response ollamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return Completion{}, fmt.Errorf("error decoding response: %w", err)
+	}
+	if response.Error != "" {
+		return Completion{}, fmt.Errorf("api error: %s", response.Error)
+	}
+	return Completion{Content: response.Message.Content, Reasoning: response.Message.Thinking, ToolCalls: fromOllamaToolCalls(response.Message.ToolCalls), Usage: response.usage()}, nil
+}
+
+// ChatCompletionStream reads the newline-delimited JSON stream of Ollama and calls onDelta for every piece of text.
+func (c *OllamaClient) ChatCompletionStream(ctx context.Context, req ChatRequest, onDelta func(delta string)) (Completion, error) {
+	resp, err := c.post(ctx, req, true)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	var (
+		full      strings.Builder
+		thinking  strings.Builder
+		usage     Usage
+		toolCalls []ollamaToolCall
+		done      bool // The last chunk has "done": true, without it the answer was cut off
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return Completion{}, fmt.Errorf("error decoding stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return Completion{}, fmt.Errorf("api error: %s", chunk.Error)
+		}
+		toolCalls = append(toolCalls, chunk.Message.ToolCalls...) // Every call comes whole in one chunk
+		if chunk.Message.Thinking != "" {
+			thinking.WriteString(chunk.Message.Thinking)
+			if req.OnReasoning != nil {
+				req.OnReasoning(chunk.Message.Thinking)
+			}
+		}
+		if chunk.Message.Content != "" {
+			full.WriteString(chunk.Message.Content)
+			if onDelta != nil {
+				onDelta(chunk.Message.Content)
+			}
+		}
+		if chunk.Done {
+			usage = chunk.usage()
+			done = true
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Completion{}, fmt.Errorf("error reading stream: %w", err)
+	}
+	if !done {
+		return Completion{}, fmt.Errorf("stream ended before done: %w", io.ErrUnexpectedEOF)
+	}
+	return Completion{Content: full.String(), Reasoning: thinking.String(), ToolCalls: fromOllamaToolCalls(toolCalls), Usage: usage}, nil
+}
+
+func (c *OllamaClient) post(ctx context.Context, req ChatRequest, stream bool) (*http.Response, error) {
+	body := ollamaRequest{
+		Model:    req.Model,
+		Messages: toOllamaMessages(req.Messages),
+		Stream:   stream,
+	}
+	if req.ToolChoice != ToolChoiceNone { // Ollama has no tool_choice, leaving the tools out forbids calls
+		body.Tools = req.Tools
+	}
+	if body.Model == "" {
+		body.Model = c.Model
+	}
+	options := map[string]any{} // Generation parameters are passed in "options" instead of top-level fields
+	if req.Temperature != nil {
+		options["temperature"] = *req.Temperature
+	}
+	if req.MaxTokens != 0 {
+		options["num_predict"] = req.MaxTokens
+	}
+	if len(options) > 0 {
+		body.Options = options
+	}
+
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+	return c.Retry.do(ctx, func() (*http.Response, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/api/chat", bytes.NewReader(reqBody))
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		httpReq.Header.Set("Content-type", "application/json")
+		resp, err := c.HTTPClient.Do(httpReq)
+		if err != nil {
+			return nil, fmt.Errorf("error sending request: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return nil, newAPIError(resp, body)
+		}
+		return resp, nil
+	})
+}
@@ -0,0 +1,225 @@
+// Tasks: Sending requests to any API compatible with the OpenAI chat completions format.
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type ChatResponse struct {
+	Choices []struct {
+		Message struct {
+			Message
+			ReasoningContent string `json:"reasoning_content"` // Thinking of reasoning models such as deepseek-reasoner
+		} `json:"message"`
+	} `json:"choices"`
+	Usage *ChatUsage `json:"usage"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type ChatStreamChunk struct { // One server-sent event of a streamed completion
+	Choices []struct {
+		Delta struct {
+			Content          string          `json:"content"`
+			ReasoningContent string          `json:"reasoning_content"`
+			ToolCalls        []toolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *ChatUsage `json:"usage"` // Sent in the last event when stream_options.include_usage is set
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type ChatUsage struct {
+	PromptTokens         int `json:"prompt_tokens"`
+	CompletionTokens     int `json:"completion_tokens"`
+	PromptCacheHitTokens int `json:"prompt_cache_hit_tokens"` // DeepSeek reports cached tokens here
+	PromptTokensDetails  struct {
+		CachedTokens int `json:"cached_tokens"` // OpenAI reports cached tokens here
+	} `json:"prompt_tokens_details"`
+}
+
+func (u *ChatUsage) toUsage() Usage {
+	if u == nil {
+		return Usage{}
+	}
+	return Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		CachedTokens:     max(u.PromptCacheHitTokens, u.PromptTokensDetails.CachedTokens),
+	}
+}
+
+type OpenAIClient struct {
+	ProviderName string
+	APIKey       string
+	HTTPClient   *http.Client
+	BaseURL      string // URL without /chat/completions
+	Model        string // Model used when the request does not specify one
+	Retry        RetryPolicy
+}
+
+const openAIBaseURL = "https://api.openai.com/v1"
+
+func NewOpenAIClient(name, apiKey, baseURL, model string) *OpenAIClient {
+	if baseURL == "" {
+		baseURL = openAIBaseURL
+	}
+	return &OpenAIClient{
+		ProviderName: name,
+		APIKey:       apiKey,
+		HTTPClient: &http.Client{
+			Timeout: requestTimeout,
+		},
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Model:   model,
+		Retry:   DefaultRetryPolicy,
+	}
+}
+
+func (c *OpenAIClient) Name() string {
+	return c.ProviderName
+}
+
+func (c *OpenAIClient) ChatCompletion(ctx context.Context, req ChatRequest) (Completion, error) {
+	req.Stream = false
+	req.StreamOptions = nil
+	resp, err := c.post(ctx, req)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+	var response ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil { // Decode the response from the api
+		return Completion{}, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	if len(response.Choices) == 0 { // If we haven't received a response
+		if response.Error.Message != "" {
+			return Completion{}, fmt.Errorf("api error: %s", response.Error.Message)
+		}
+		return Completion{}, fmt.Errorf("no choices in response")
+	}
+	return Completion{ // If we receive a response - return it
+		Content:   response.Choices[0].Message.Content,
+		Reasoning: response.Choices[0].Message.ReasoningContent,
+		ToolCalls: response.Choices[0].Message.ToolCalls,
+		Usage:     response.Usage.toUsage(),
+	}, nil
+}
+
+// ChatCompletionStream requests a streamed completion and calls onDelta for every piece of text as it arrives.
+// The full text is returned once the server sends [DONE], a stream closed before it is an io.ErrUnexpectedEOF.
+func (c *OpenAIClient) ChatCompletionStream(ctx context.Context, req ChatRequest, onDelta func(delta string)) (Completion, error) {
+	req.Stream = true
+	req.StreamOptions = &StreamOptions{IncludeUsage: true}
+	resp, err := c.post(ctx, req)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	var (
+		full      strings.Builder
+		reasoning strings.Builder
+		usage     *ChatUsage
+		toolCalls []ToolCall
+		done      bool // The answer is complete only after [DONE], a dropped connection also ends with EOF
+	)
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return Completion{}, fmt.Errorf("error reading stream: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		// Events look like "data: {...}", the stream ends with "data: [DONE]".
+		// Empty lines separate events, lines starting with ":" are keep-alive comments.
+		if data, ok := strings.CutPrefix(line, "data:"); ok {
+			data = strings.TrimSpace(data)
+			if data == "[DONE]" {
+				done = true
+				break
+			}
+			var chunk ChatStreamChunk
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return Completion{}, fmt.Errorf("error decoding stream chunk: %w", err)
+			}
+			if chunk.Error.Message != "" {
+				return Completion{}, fmt.Errorf("api error: %s", chunk.Error.Message)
+			}
+			if chunk.Usage != nil {
+				usage = chunk.Usage
+			}
+			for _, choice := range chunk.Choices {
+				for _, delta := range choice.Delta.ToolCalls {
+					toolCalls = appendToolCallDelta(toolCalls, delta)
+				}
+				if choice.Delta.ReasoningContent != "" {
+					reasoning.WriteString(choice.Delta.ReasoningContent)
+					if req.OnReasoning != nil {
+						req.OnReasoning(choice.Delta.ReasoningContent)
+					}
+				}
+				if choice.Delta.Content == "" {
+					continue
+				}
+				full.WriteString(choice.Delta.Content)
+				if onDelta != nil {
+					onDelta(choice.Delta.Content)
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if !done {
+		return Completion{}, fmt.Errorf("stream ended before [DONE]: %w", io.ErrUnexpectedEOF)
+	}
+	return Completion{Content: full.String(), Reasoning: reasoning.String(), ToolCalls: toolCalls, Usage: usage.toUsage()}, nil
+}
+
+func (c *OpenAIClient) post(ctx context.Context, req ChatRequest) (*http.Response, error) { // Sends the request and checks the response status
+	if req.Model == "" {
+		req.Model = c.Model
+	}
+	reqBody, err := json.Marshal(req) // Marshal the request to json to send the request to the api
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	return c.Retry.do(ctx, func() (*http.Response, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/chat/completions", bytes.NewReader(reqBody)) // Creating a request to api
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		httpReq.Header.Set("Content-type", "application/json")
+		if c.APIKey != "" { // Self-hosted servers usually work without a key
+			httpReq.Header.Set("Authorization", "Bearer "+c.APIKey)
+		}
+		if req.Stream {
+			httpReq.Header.Set("Accept", "text/event-stream")
+		}
+		resp, err := c.HTTPClient.Do(httpReq) // We execute the request
+		if err != nil {
+			return nil, fmt.Errorf("error sending request: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return nil, newAPIError(resp, body)
+		}
+		return resp, nil
+	})
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIChatCompletionStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		pieces     []string // Written and flushed one by one, so an event can arrive split between reads
+		wantDeltas []string
+		want       Completion
+		wantErr    error  // Checked with errors.Is
+		errText    string // Part of the expected error message
+	}{
+		{
+			name: "whole events",
+			pieces: []string{
+				"data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n",
+				"data: {\"choices\":[{\"delta\":{\"content\":\", world\"}}]}\n\n",
+				"data: [DONE]\n\n",
+			},
+			wantDeltas: []string{"Hello", ", world"},
+			want:       Completion{Content: "Hello, world"},
+		},
+		{
+			name: "event split between reads",
+			pieces: []string{
+				"data: {\"choices\":[{\"del",
+				"ta\":{\"content\":\"Hel",
+				"lo\"}}]}\n",
+				"\ndata: [DO",
+				"NE]\n\n",
+			},
+			wantDeltas: []string{"Hello"},
+			want:       Completion{Content: "Hello"},
+		},
+		{
+			name: "keep-alive comments and blank lines",
+			pieces: []string{
+				": keep-alive\n\n",
+				"\r\n",
+				"data:{\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\r\n\r\n",
+				": keep-alive\n\n",
+				"data: [DONE]\n\n",
+			},
+			wantDeltas: []string{"Hi"},
+			want:       Completion{Content: "Hi"},
+		},
+		{
+			name: "done without a trailing newline",
+			pieces: []string{
+				"data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n",
+				"data: [DONE]",
+			},
+			wantDeltas: []string{"Hi"},
+			want:       Completion{Content: "Hi"},
+		},
+		{
+			name: "events after done are ignored",
+			pieces: []string{
+				"data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n",
+				"data: [DONE]\n\n",
+				"data: {\"choices\":[{\"delta\":{\"content\":\" again\"}}]}\n\n",
+			},
+			wantDeltas: []string{"Hi"},
+			want:       Completion{Content: "Hi"},
+		},
+		{
+			name: "reasoning, tool call and usage",
+			pieces: []string{
+				"data: {\"choices\":[{\"delta\":{\"reasoning_content\":\"Let me think\"}}]}\n\n",
+				"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"function\":{\"name\":\"calculator\",\"arguments\":\"{\\\"expr\"}}]}}]}\n\n",
+				"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"ession\\\":\\\"2+2\\\"}\"}}]}}]}\n\n",
+				"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":5,\"prompt_tokens_details\":{\"cached_tokens\":8}}}\n\n",
+				"data: [DONE]\n\n",
+			},
+			want: Completion{
+				Reasoning: "Let me think",
+				ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: FunctionCall{Name: "calculator", Arguments: `{"expression":"2+2"}`}}},
+				Usage:     Usage{PromptTokens: 12, CompletionTokens: 5, CachedTokens: 8},
+			},
+		},
+		{
+			name: "connection closed before done",
+			pieces: []string{
+				"data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n",
+			},
+			wantDeltas: []string{"Hel"},
+			wantErr:    io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "empty stream",
+			pieces:  []string{},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name: "error event",
+			pieces: []string{
+				"data: {\"error\":{\"message\":\"overloaded\"}}\n\n",
+			},
+			errText: "api error: overloaded",
+		},
+		{
+			name: "broken json",
+			pieces: []string{
+				"data: {\"choices\":\n\n",
+			},
+			errText: "error decoding stream chunk",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				flusher := w.(http.Flusher)
+				for _, piece := range tt.pieces {
+					io.WriteString(w, piece)
+					flusher.Flush()
+				}
+			}))
+			defer server.Close()
+
+			client := NewOpenAIClient("test", "key", server.URL, "model")
+			client.Retry = RetryPolicy{}
+			var deltas []string
+			got, err := client.ChatCompletionStream(context.Background(), ChatRequest{}, func(delta string) {
+				deltas = append(deltas, delta)
+			})
+
+			if strings.Join(deltas, "|") != strings.Join(tt.wantDeltas, "|") {
+				t.Errorf("deltas = %q, want %q", deltas, tt.wantDeltas)
+			}
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case tt.errText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("error = %v, want %q", err, tt.errText)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Content != tt.want.Content || got.Reasoning != tt.want.Reasoning || got.Usage != tt.want.Usage {
+				t.Errorf("completion = %+v, want %+v", got, tt.want)
+			}
+			if len(got.ToolCalls) != len(tt.want.ToolCalls) {
+				t.Fatalf("tool calls = %+v, want %+v", got.ToolCalls, tt.want.ToolCalls)
+			}
+			for i := range got.ToolCalls {
+				if got.ToolCalls[i] != tt.want.ToolCalls[i] {
+					t.Errorf("tool call %d = %+v, want %+v", i, got.ToolCalls[i], tt.want.ToolCalls[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+// Tasks: Common request/response types and the interface every neural network backend implements.
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"quokka-ai-bot/config"
+	"time"
+)
+
+type ChatRequest struct {
+	Model       string     `json:"model"`
+	Messages    []Message  `json:"messages"`
+	Temperature *float64   `json:"temperature,omitempty"` // Nil leaves the default of the model
+	MaxTokens   int        `json:"max_tokens,omitempty"`
+	Stream      bool       `json:"stream,omitempty"`
+	Tools       []ToolSpec `json:"tools,omitempty"`
+	ToolChoice  string     `json:"tool_choice,omitempty"` // ToolChoiceNone forbids calls, empty lets the model decide
+	// OnReasoning is called for every piece of reasoning of a streamed answer. The fallback sets it to see that a thinking model is alive
+	OnReasoning func(delta string) `json:"-"`
+	// StreamOptions asks OpenAI compatible APIs to send token usage in the last event of a stream
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// Message is one turn of a conversation. Content is its text; a message with an image also has Parts,
+// which are sent instead of Content as multimodal content. Content then keeps the text for the history and estimates.
+type Message struct {
+	Role       string        `json:"role"`
+	Content    string        `json:"content"`
+	Parts      []ContentPart `json:"-"`
+	ToolCalls  []ToolCall    `json:"tool_calls,omitempty"`   // Tools an assistant message asks to call
+	ToolCallID string        `json:"tool_call_id,omitempty"` // The call a "tool" message answers
+}
+
+// ContentPart is a piece of multimodal message content in the OpenAI format.
+type ContentPart struct {
+	Type     string    `json:"type"` // text or image_url
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+type ImageURL struct {
+	URL string `json:"url"` // A data URL with the base64 encoded image
+}
+
+// TextPart and ImagePart build the parts of a message with an image.
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: "text", Text: text}
+}
+
+func ImagePart(mime string, data []byte) ContentPart {
+	return ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data)}}
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	if len(m.Parts) == 0 {
+		type plain Message // Without the method, so the default encoding is used
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		Role    string        `json:"role"`
+		Content []ContentPart `json:"content"`
+	}{m.Role, m.Parts})
+}
+
+type Usage struct { // Tokens spent on one answer
+	PromptTokens     int
+	CompletionTokens int
+	CachedTokens     int // Part of the prompt served from the provider's cache
+}
+
+type Completion struct { // An answer of a provider
+	Content   string
+	Reasoning string     // Thinking of a reasoning model before the answer. It must not be sent back in the history
+	ToolCalls []ToolCall // Tools the model wants to call before it answers
+	Usage     Usage
+}
+
+// ChatProvider is a backend that can answer a conversation.
+// If ChatRequest.Model is empty, the provider uses the model from its configuration.
+type ChatProvider interface {
+	Name() string
+	ChatCompletion(ctx context.Context, req ChatRequest) (Completion, error)
+	ChatCompletionStream(ctx context.Context, req ChatRequest, onDelta func(delta string)) (Completion, error)
+}
+
+const requestTimeout = 3 * time.Minute // Maximum time of a single request to a provider
+
+// NewChatProvider creates the provider with the given name from the configuration.
+func NewChatProvider(cfg *config.Config, name string) (ChatProvider, error) {
+	pc, err := cfg.LookupProvider(name)
+	if err != nil {
+		return nil, err
+	}
+	retry := DefaultRetryPolicy
+	retry.MaxRetries = cfg.Retries()
+	switch pc.Type {
+	case config.ProviderDeepSeek:
+		client := NewDeepSeekClient(pc.Token, pc.BaseURL, pc.Model)
+		client.Retry = retry
+		return client, nil
+	case config.ProviderOpenAI:
+		client := NewOpenAIClient(name, pc.Token, pc.BaseURL, pc.Model)
+		client.Retry = retry
+		return client, nil
+	case config.ProviderOllama:
+		client := NewOllamaClient(pc.BaseURL, pc.Model)
+		client.Retry = retry
+		return client, nil
+	case config.ProviderLlamaCpp:
+		client := NewLlamaCppClient(pc.Token, pc.BaseURL, pc.Model)
+		client.Retry = retry
+		return client, nil
+	}
+	return nil, fmt.Errorf("unknown provider type %q for provider %q", pc.Type, name)
+}
+
+// ModelRoute is a provider together with the model it should be asked for.
+type ModelRoute struct {
+	Provider ChatProvider
+	Model    string // Empty means the default model of the provider
+}
+
+func (r ModelRoute) String() string { // Name of the route as it is stored next to the answers
+	if r.Model == "" {
+		return r.Provider.Name()
+	}
+	return r.Provider.Name() + "/" + r.Model
+}
+
+// Providers creates the configured providers on first use and keeps them, so several models of one provider,
+// in the main chain and in the vision chain alike, share a client and a circuit breaker.
+type Providers struct {
+	cfg     *config.Config
+	created map[string]ChatProvider
+}
+
+func NewProviders(cfg *config.Config) *Providers {
+	return &Providers{cfg: cfg, created: make(map[string]ChatProvider)}
+}
+
+// ModelChain builds the ordered list of routes to try: the main provider first, then the fallback entries.
+func (p *Providers) ModelChain() ([]ModelRoute, error) {
+	return p.chain(append([]config.FallbackEntry{{Provider: p.cfg.Provider}}, p.cfg.Fallback...))
+}
+
+// VisionChain builds the routes that answer messages with images, nil if no vision model is configured.
+func (p *Providers) VisionChain() ([]ModelRoute, error) {
+	if len(p.cfg.Vision) == 0 {
+		return nil, nil
+	}
+	return p.chain(p.cfg.Vision)
+}
+
+func (p *Providers) get(name string) (ChatProvider, error) {
+	if provider, ok := p.created[name]; ok {
+		return provider, nil
+	}
+	provider, err := NewChatProvider(p.cfg, name)
+	if err != nil {
+		return nil, err
+	}
+	if p.cfg.Breaker.IsEnabled() {
+		provider = NewCircuitBreaker(provider, BreakerSettings{
+			FailureRatio: p.cfg.Breaker.FailureRatio,
+			MinRequests:  p.cfg.Breaker.MinRequests,
+			Window:       time.Duration(p.cfg.Breaker.Window) * time.Second,
+			OpenTimeout:  time.Duration(p.cfg.Breaker.OpenTimeout) * time.Second,
+			Probes:       p.cfg.Breaker.Probes,
+		})
+	}
+	p.created[name] = provider
+	return provider, nil
+}
+
+func (p *Providers) chain(entries []config.FallbackEntry) ([]ModelRoute, error) {
+	chain := make([]ModelRoute, 0, len(entries))
+	for _, entry := range entries {
+		provider, err := p.get(entry.Provider)
+		if err != nil {
+			return nil, err
+		}
+		model := entry.Model
+		if model == "" { // Record the real model name rather than an empty string
+			if pc, err := p.cfg.LookupProvider(entry.Provider); err == nil {
+				model = pc.Model
+			}
+		}
+		chain = append(chain, ModelRoute{Provider: provider, Model: model})
+	}
+	return chain, nil
+}
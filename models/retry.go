@@ -0,0 +1,58 @@
+// Tasks: Repeating failed requests to providers with exponential backoff.
+package models
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+type RetryPolicy struct {
+	MaxRetries int           // Number of repeats after the first attempt, 0 disables retries
+	BaseDelay  time.Duration // Delay before the first repeat, doubled for every next one
+	MaxDelay   time.Duration // Upper bound of a delay. A longer Retry-After is not waited for
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  time.Second,
+	MaxDelay:   30 * time.Second,
+}
+
+// do calls send until it succeeds, fails with a non-retryable error or the attempts run out.
+// send must create a new request every time, because a request body can be read only once.
+func (p RetryPolicy) do(ctx context.Context, send func() (*http.Response, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := send()
+		if err == nil || attempt >= p.MaxRetries || !IsRetryable(err) || ctx.Err() != nil {
+			return resp, err
+		}
+		delay, ok := p.delay(attempt, err)
+		if !ok {
+			return nil, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns how long to wait before the next attempt: the Retry-After value if the provider sent one,
+// otherwise an exponential delay with jitter so that many users do not retry at the same moment.
+func (p RetryPolicy) delay(attempt int, err error) (time.Duration, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter, apiErr.RetryAfter <= p.MaxDelay
+	}
+	backoff := p.BaseDelay << attempt
+	if backoff > p.MaxDelay || backoff <= 0 {
+		backoff = p.MaxDelay
+	}
+	return backoff/2 + rand.N(backoff/2+1), true
+}
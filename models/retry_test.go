@@ -0,0 +1,96 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{MaxRetries: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+	tests := []struct {
+		name     string
+		attempt  int
+		err      error
+		min, max time.Duration
+		ok       bool
+	}{
+		{"first backoff", 0, &APIError{Kind: ErrServerError}, 500 * time.Millisecond, time.Second, true},
+		{"third backoff", 2, &APIError{Kind: ErrServerError}, 2 * time.Second, 4 * time.Second, true},
+		{"backoff capped", 10, &APIError{Kind: ErrServerError}, 15 * time.Second, 30 * time.Second, true},
+		{"huge attempt does not overflow", 70, &APIError{Kind: ErrServerError}, 15 * time.Second, 30 * time.Second, true},
+		{"retry after", 0, &APIError{Kind: ErrRateLimited, RetryAfter: 12 * time.Second}, 12 * time.Second, 12 * time.Second, true},
+		{"retry after at the limit", 0, &APIError{Kind: ErrRateLimited, RetryAfter: 30 * time.Second}, 30 * time.Second, 30 * time.Second, true},
+		{"retry after too long", 0, &APIError{Kind: ErrRateLimited, RetryAfter: time.Minute}, time.Minute, time.Minute, false},
+		{"not an api error", 1, context.DeadlineExceeded, time.Second, 2 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 20 { // The jitter is random, every value has to be in the range
+				got, ok := p.delay(tt.attempt, tt.err)
+				if ok != tt.ok || got < tt.min || got > tt.max {
+					t.Fatalf("delay(%d) = %v, %v, want between %v and %v, %v", tt.attempt, got, ok, tt.min, tt.max, tt.ok)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		retryAfter string
+		maxRetries int
+		wantCalls  int
+		wantStatus int
+	}{
+		{"success at once", []int{200}, "", 3, 1, 200},
+		{"server error then success", []int{503, 500, 200}, "", 3, 3, 200},
+		{"rate limit with retry after", []int{429, 200}, "0", 3, 2, 200},
+		{"attempts run out", []int{500, 500, 500}, "", 2, 3, 500},
+		{"retries disabled", []int{500, 200}, "", 0, 1, 500},
+		{"bad request is not repeated", []int{400, 200}, "", 3, 1, 400},
+		{"retry after too long", []int{429, 200}, "3600", 3, 1, 429},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[min(calls, len(tt.statuses)-1)]
+				calls++
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			p := RetryPolicy{MaxRetries: tt.maxRetries, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+			ctx := context.Background()
+			resp, err := p.do(ctx, func() (*http.Response, error) {
+				resp, err := http.Get(server.URL)
+				if err != nil {
+					return nil, err
+				}
+				if resp.StatusCode != http.StatusOK {
+					resp.Body.Close()
+					return nil, newAPIError(resp, nil)
+				}
+				return resp, nil
+			})
+			status := 0
+			if resp != nil {
+				resp.Body.Close()
+				status = resp.StatusCode
+			} else if apiErr, ok := err.(*APIError); ok {
+				status = apiErr.StatusCode
+			}
+			if calls != tt.wantCalls || status != tt.wantStatus {
+				t.Errorf("got %d calls and status %d, want %d calls and status %d (err %v)", calls, status, tt.wantCalls, tt.wantStatus, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Tasks: Turning voice messages into text through a speech recognition server.
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"quokka-ai-bot/config"
+	"strings"
+)
+
+// Transcriber converts a recording into text. fileName tells the server the audio format.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio io.Reader, fileName string) (string, error)
+}
+
+// WhisperClient sends recordings to an OpenAI compatible /audio/transcriptions endpoint
+// or to the /inference endpoint of whisper.cpp server, both take the same multipart form.
+type WhisperClient struct {
+	APIKey     string
+	HTTPClient *http.Client
+	URL        string // Full URL of the endpoint
+	Model      string
+	Language   string // ISO 639-1 code, empty lets the server detect it
+	Retry      RetryPolicy
+}
+
+// NewTranscriber creates the speech recognition backend from the configuration, nil if it is not configured.
+func NewTranscriber(cfg *config.Config) (Transcriber, error) {
+	sc := cfg.Speech
+	var url string
+	switch sc.Type {
+	case "":
+		return nil, nil
+	case config.SpeechOpenAI:
+		baseURL := sc.BaseURL
+		if baseURL == "" {
+			baseURL = openAIBaseURL
+		}
+		url = strings.TrimSuffix(baseURL, "/") + "/audio/transcriptions"
+	case config.SpeechWhisperCpp:
+		baseURL := sc.BaseURL
+		if baseURL == "" {
+			baseURL = "http://localhost:8081"
+		}
+		url = strings.TrimSuffix(baseURL, "/") + "/inference"
+	default:
+		return nil, fmt.Errorf("unknown speech type %q", sc.Type)
+	}
+	retry := DefaultRetryPolicy
+	retry.MaxRetries = cfg.Retries()
+	return &WhisperClient{
+		APIKey: sc.Token,
+		HTTPClient: &http.Client{
+			Timeout: requestTimeout,
+		},
+		URL:      url,
+		Model:    sc.Model,
+		Language: sc.Language,
+		Retry:    retry,
+	}, nil
+}
+
+func (c *WhisperClient) Transcribe(ctx context.Context, audio io.Reader, fileName string) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, audio); err != nil {
+		return "", fmt.Errorf("error reading audio: %w", err)
+	}
+	fields := map[string]string{"model": c.Model, "language": c.Language, "response_format": "json"}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := form.WriteField(name, value); err != nil {
+			return "", err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	resp, err := c.Retry.do(ctx, func() (*http.Response, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", c.URL, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", form.FormDataContentType())
+		if c.APIKey != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+c.APIKey)
+		}
+		resp, err := c.HTTPClient.Do(httpReq)
+		if err != nil {
+			return nil, fmt.Errorf("error sending request: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			respBody, _ := io.ReadAll(resp.Body)
+			return nil, newAPIError(resp, respBody)
+		}
+		return resp, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var transcription struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&transcription); err != nil {
+		return "", fmt.Errorf("error decoding response: %w", err)
+	}
+	return strings.TrimSpace(transcription.Text), nil
+}
@@ -0,0 +1,48 @@
+// Tasks: Estimating how many tokens a text takes without calling the provider.
+package models
+
+import "unicode"
+
+// TokenEstimator estimates the number of tokens a text takes for the model.
+// Implementations may use a real tokenizer, the handlers only need an estimate to choose how much history fits.
+type TokenEstimator interface {
+	EstimateTokens(text string) int
+}
+
+// HeuristicEstimator counts tokens by character classes, which is close enough for BPE tokenizers:
+// about 4 latin characters, 2.5 cyrillic characters or 1 CJK character per token.
+type HeuristicEstimator struct{}
+
+func (HeuristicEstimator) EstimateTokens(text string) int {
+	var ascii, other, cjk int
+	for _, r := range text {
+		switch {
+		case r < unicode.MaxASCII:
+			ascii++
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			cjk++
+		default:
+			other++
+		}
+	}
+	return (ascii+3)/4 + (other*2+4)/5 + cjk
+}
+
+const (
+	messageOverhead = 4    // Tokens spent on the role and separators of every message
+	imageTokens     = 1000 // Rough size of an image, providers count it by its resolution
+)
+
+// EstimateMessages returns the estimated size of the messages in tokens.
+func EstimateMessages(estimator TokenEstimator, messages []Message) int {
+	total := 0
+	for _, msg := range messages {
+		total += estimator.EstimateTokens(msg.Content) + messageOverhead
+		for _, part := range msg.Parts {
+			if part.ImageURL != nil {
+				total += imageTokens
+			}
+		}
+	}
+	return total
+}
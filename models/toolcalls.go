@@ -0,0 +1,57 @@
+// Tasks: Types of function calling: the tools offered to the model and the calls it makes.
+package models
+
+import "encoding/json"
+
+// ToolSpec describes a function the model may call, in the OpenAI format.
+type ToolSpec struct {
+	Type     string       `json:"type"` // Always "function"
+	Function FunctionSpec `json:"function"`
+}
+
+type FunctionSpec struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"` // JSON schema of the arguments
+}
+
+// ToolCall is a call of a tool requested by the model. The result is sent back in a "tool" message with the same ID.
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON object as a string
+}
+
+const ToolChoiceNone = "none" // Forbids tool calls, the model has to answer with text
+
+// toolCallDelta is a piece of a tool call in a streamed answer. The ID and the name come in the first piece,
+// the arguments are split over many of them.
+type toolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+func appendToolCallDelta(calls []ToolCall, delta toolCallDelta) []ToolCall {
+	for len(calls) <= delta.Index {
+		calls = append(calls, ToolCall{Type: "function"})
+	}
+	call := &calls[delta.Index]
+	if delta.ID != "" {
+		call.ID = delta.ID
+	}
+	if delta.Function.Name != "" {
+		call.Function.Name = delta.Function.Name
+	}
+	call.Function.Arguments += delta.Function.Arguments
+	return calls
+}
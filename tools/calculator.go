@@ -0,0 +1,200 @@
+// Tasks: Calculator tool, evaluates arithmetic expressions exactly instead of letting the model guess.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+func Calculator() Tool {
+	return Tool{
+		Name:        "calculator",
+		Description: "Evaluates an arithmetic expression. Supports + - * / % ^, parentheses, the constants pi and e and the functions sqrt, abs, round, floor, ceil, ln, log (base 10), log2, exp, sin, cos, tan, asin, acos, atan (radians).",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"expression": {"type": "string", "description": "The expression, for example (2 + 3) * sqrt(16) / 2^3"}
+			},
+			"required": ["expression"]
+		}`),
+		Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Expression string `json:"expression"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			value, err := Evaluate(args.Expression)
+			if err != nil {
+				return "", err
+			}
+			return strconv.FormatFloat(value, 'g', 15, 64), nil
+		},
+	}
+}
+
+var calcFunctions = map[string]func(float64) float64{
+	"sqrt": math.Sqrt, "abs": math.Abs, "round": math.Round, "floor": math.Floor, "ceil": math.Ceil,
+	"ln": math.Log, "log": math.Log10, "log2": math.Log2, "exp": math.Exp,
+	"sin": math.Sin, "cos": math.Cos, "tan": math.Tan, "asin": math.Asin, "acos": math.Acos, "atan": math.Atan,
+}
+
+var calcConstants = map[string]float64{"pi": math.Pi, "e": math.E}
+
+// Evaluate computes an arithmetic expression. ^ binds tighter than unary minus, as in -2^2 = -4.
+func Evaluate(expression string) (float64, error) {
+	p := &calcParser{input: strings.ToLower(expression)}
+	value, err := p.expression()
+	if err != nil {
+		return 0, err
+	}
+	if p.skipSpaces(); p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos:], p.pos+1)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, errors.New("the result is not a finite number")
+	}
+	return value, nil
+}
+
+type calcParser struct { // Recursive descent: expression = term {(+|-) term}, term = unary {(*|/|%) unary}, unary = [-] power, power = primary [^ unary]
+	input string
+	pos   int
+}
+
+func (p *calcParser) skipSpaces() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *calcParser) peek() byte {
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *calcParser) expression() (float64, error) {
+	value, err := p.term()
+	for err == nil {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			break
+		}
+		p.pos++
+		var right float64
+		if right, err = p.term(); op == '+' {
+			value += right
+		} else {
+			value -= right
+		}
+	}
+	return value, err
+}
+
+func (p *calcParser) term() (float64, error) {
+	value, err := p.unary()
+	for err == nil {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			break
+		}
+		p.pos++
+		var right float64
+		if right, err = p.unary(); err != nil {
+			break
+		}
+		switch {
+		case op == '*':
+			value *= right
+		case right == 0:
+			return 0, errors.New("division by zero")
+		case op == '/':
+			value /= right
+		default:
+			value = math.Mod(value, right)
+		}
+	}
+	return value, err
+}
+
+func (p *calcParser) unary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		value, err := p.unary()
+		return -value, err
+	case '+':
+		p.pos++
+		return p.unary()
+	}
+	return p.power()
+}
+
+func (p *calcParser) power() (float64, error) {
+	base, err := p.primary()
+	if err != nil || p.peek() != '^' {
+		return base, err
+	}
+	p.pos++
+	exponent, err := p.unary() // Right associative: 2^3^2 = 2^9
+	return math.Pow(base, exponent), err
+}
+
+func (p *calcParser) primary() (float64, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		value, err := p.expression()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return value, nil
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.input) && (unicode.IsDigit(rune(p.input[p.pos])) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		if p.pos < len(p.input) && p.input[p.pos] == 'e' && p.pos+1 < len(p.input) && strings.ContainsRune("0123456789+-", rune(p.input[p.pos+1])) { // 1e-3
+			p.pos += 2
+			for p.pos < len(p.input) && unicode.IsDigit(rune(p.input[p.pos])) {
+				p.pos++
+			}
+		}
+		return strconv.ParseFloat(p.input[start:p.pos], 64)
+	case c >= 'a' && c <= 'z':
+		start := p.pos
+		for p.pos < len(p.input) && (p.input[p.pos] >= 'a' && p.input[p.pos] <= 'z' || unicode.IsDigit(rune(p.input[p.pos]))) {
+			p.pos++
+		}
+		name := p.input[start:p.pos]
+		if value, ok := calcConstants[name]; ok {
+			return value, nil
+		}
+		fn, ok := calcFunctions[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown name %q", name)
+		}
+		if p.peek() != '(' {
+			return 0, fmt.Errorf("%s needs an argument in parentheses", name)
+		}
+		argument, err := p.primary()
+		return fn(argument), err
+	case c == 0:
+		return 0, errors.New("unexpected end of expression")
+	}
+	return 0, fmt.Errorf("unexpected %q at position %d", c, p.pos+1)
+}
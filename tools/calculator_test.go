@@ -0,0 +1,59 @@
+package tools
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 / 4", 2.5},
+		{"10 % 4", 2},
+		{"2 - 3 - 4", -5},
+		{"-2^2", -4},
+		{"2^3^2", 512},
+		{"2^-1", 0.5},
+		{"--3", 3},
+		{"1.5e3 + 1e-3", 1500.001},
+		{"sqrt(16) + abs(-2)", 6},
+		{"round(2.5) + floor(1.9) + ceil(1.1)", 6},
+		{"log(1000)", 3},
+		{"2 * PI", 2 * math.Pi},
+		{"e", math.E},
+	}
+	for _, tt := range tests {
+		got, err := Evaluate(tt.expression)
+		if err != nil {
+			t.Errorf("Evaluate(%q) returned error: %v", tt.expression, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Evaluate(%q) = %v, want %v", tt.expression, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"1 +",
+		"1 / 0",
+		"5 % 0",
+		"(1 + 2",
+		"1 + 2)",
+		"foo(1)",
+		"sqrt 4",
+		"sqrt(-1)",
+		"10^400",
+		"2 $ 3",
+	} {
+		if got, err := Evaluate(expression); err == nil {
+			t.Errorf("Evaluate(%q) = %v, want an error", expression, got)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Tasks: Date and time tool, the model does not know the current date by itself.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type locationKey struct{}
+
+// WithLocation returns a context in which the date and time tool answers in the time zone of the user who asks.
+func WithLocation(ctx context.Context, location *time.Location) context.Context {
+	return context.WithValue(ctx, locationKey{}, location)
+}
+
+// DateTime returns the tool that tells the current date and time in the user's time zone from the context,
+// in location if the user has not set one, or in another time zone the model asks for.
+func DateTime(location *time.Location) Tool {
+	return Tool{
+		Name:        "current_datetime",
+		Description: "Returns the current date, time and day of the week in the user's time zone. Pass an IANA time zone only if the user asks about a different place.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"timezone": {"type": "string", "description": "IANA time zone, for example Europe/Moscow or America/New_York"}
+			}
+		}`),
+		Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Timezone string `json:"timezone"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			loc := location
+			if userLoc, ok := ctx.Value(locationKey{}).(*time.Location); ok && userLoc != nil {
+				loc = userLoc
+			}
+			if args.Timezone != "" {
+				var err error
+				if loc, err = time.LoadLocation(args.Timezone); err != nil {
+					return "", fmt.Errorf("unknown time zone %q", args.Timezone)
+				}
+			}
+			now := time.Now().In(loc)
+			return fmt.Sprintf("%s, %s (%s, UTC%s)", now.Format("2006-01-02 15:04:05"), now.Weekday(), loc, now.Format("-07:00")), nil
+		},
+	}
+}
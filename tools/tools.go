@@ -0,0 +1,108 @@
+// Tasks: Registry of the tools the model can call while it prepares an answer.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"quokka-ai-bot/models"
+	"time"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Tool is a Go function the model can call. Parameters is the JSON schema of the arguments,
+// Handler gets them as the model wrote them and returns the result as text.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+	Timeout     time.Duration // Zero means defaultTimeout
+	Handler     func(ctx context.Context, arguments json.RawMessage) (string, error)
+}
+
+// Registry keeps the tools in the order they were registered, which is the order they are offered to the model.
+type Registry struct {
+	tools map[string]Tool
+	order []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{tools: make(map[string]Tool)}
+}
+
+// Default returns the registry with the built-in tools. location is the time zone of the date and time tool
+// for users who have not set their own, see WithLocation.
+func Default(location *time.Location) *Registry {
+	r := NewRegistry()
+	r.Register(Calculator())
+	r.Register(DateTime(location))
+	r.Register(UnitConverter())
+	return r
+}
+
+func (r *Registry) Register(tool Tool) {
+	if _, ok := r.tools[tool.Name]; !ok {
+		r.order = append(r.order, tool.Name)
+	}
+	r.tools[tool.Name] = tool
+}
+
+// Specs describes the tools for the request.
+func (r *Registry) Specs() []models.ToolSpec {
+	specs := make([]models.ToolSpec, 0, len(r.order))
+	for _, name := range r.order {
+		tool := r.tools[name]
+		specs = append(specs, models.ToolSpec{
+			Type:     "function",
+			Function: models.FunctionSpec{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	return specs
+}
+
+// Call runs the tool the model asked for and returns the text sent back to it.
+// Failures are returned as text as well, so the model can correct the call or explain the problem.
+func (r *Registry) Call(ctx context.Context, call models.ToolCall) string {
+	tool, ok := r.tools[call.Function.Name]
+	if !ok {
+		return fmt.Sprintf("error: unknown tool %q", call.Function.Name)
+	}
+	arguments := json.RawMessage(call.Function.Arguments)
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+	timeout := tool.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type outcome struct {
+		result string
+		err    error
+	}
+	done := make(chan outcome, 1) // The handler keeps running after a timeout, but nobody waits for it
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- outcome{err: fmt.Errorf("panic: %v", p)}
+			}
+		}()
+		result, err := tool.Handler(ctx, arguments)
+		done <- outcome{result, err}
+	}()
+	select {
+	case o := <-done:
+		if o.err != nil {
+			log.Printf("[ WARN ] Tool %s failed", tool.Name) // The error quotes the arguments, it goes only to the model
+			return "error: " + o.err.Error()
+		}
+		return o.result
+	case <-ctx.Done():
+		log.Printf("[ WARN ] Tool %s timed out after %v", tool.Name, timeout)
+		return fmt.Sprintf("error: the tool did not finish in %v", timeout)
+	}
+}
@@ -0,0 +1,130 @@
+// Tasks: Unit conversion tool for length, mass, volume, area, speed, time, data and temperature.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type unit struct {
+	Kind   string
+	Factor float64 // Size of the unit in the base unit of its kind
+}
+
+var units = map[string]unit{
+	// Length, base is the metre
+	"mm": {"length", 0.001}, "cm": {"length", 0.01}, "m": {"length", 1}, "km": {"length", 1000},
+	"in": {"length", 0.0254}, "ft": {"length", 0.3048}, "yd": {"length", 0.9144}, "mi": {"length", 1609.344}, "nmi": {"length", 1852},
+	// Mass, base is the kilogram
+	"mg": {"mass", 1e-6}, "g": {"mass", 0.001}, "kg": {"mass", 1}, "t": {"mass", 1000},
+	"oz": {"mass", 0.028349523125}, "lb": {"mass", 0.45359237}, "st": {"mass", 6.35029318},
+	// Volume, base is the litre
+	"ml": {"volume", 0.001}, "l": {"volume", 1}, "m3": {"volume", 1000},
+	"tsp": {"volume", 0.00492892159375}, "tbsp": {"volume", 0.01478676478125}, "cup": {"volume", 0.2365882365},
+	"floz": {"volume", 0.0295735295625}, "pt": {"volume", 0.473176473}, "qt": {"volume", 0.946352946}, "gal": {"volume", 3.785411784},
+	// Area, base is the square metre
+	"mm2": {"area", 1e-6}, "cm2": {"area", 1e-4}, "m2": {"area", 1}, "km2": {"area", 1e6}, "ha": {"area", 1e4},
+	"ft2": {"area", 0.09290304}, "acre": {"area", 4046.8564224}, "mi2": {"area", 2589988.110336},
+	// Speed, base is metres per second
+	"m/s": {"speed", 1}, "km/h": {"speed", 1 / 3.6}, "mph": {"speed", 0.44704}, "kn": {"speed", 1852.0 / 3600},
+	// Time, base is the second
+	"ms": {"time", 0.001}, "s": {"time", 1}, "min": {"time", 60}, "h": {"time", 3600}, "day": {"time", 86400}, "week": {"time", 604800}, "year": {"time", 31557600},
+	// Data, base is the byte
+	"bit": {"data", 0.125}, "b": {"data", 1}, "kb": {"data", 1e3}, "mb": {"data", 1e6}, "gb": {"data", 1e9}, "tb": {"data", 1e12},
+	"kib": {"data", 1 << 10}, "mib": {"data", 1 << 20}, "gib": {"data", 1 << 30}, "tib": {"data", 1 << 40},
+	// Temperature is converted by formulas, see toCelsius
+	"c": {"temperature", 0}, "f": {"temperature", 0}, "k": {"temperature", 0},
+}
+
+var unitAliases = map[string]string{
+	"meter": "m", "metre": "m", "meters": "m", "metres": "m", "kilometer": "km", "kilometers": "km", "mile": "mi", "miles": "mi",
+	"inch": "in", "inches": "in", "foot": "ft", "feet": "ft", "yard": "yd", "yards": "yd",
+	"gram": "g", "grams": "g", "kilogram": "kg", "kilograms": "kg", "ton": "t", "tonne": "t", "pound": "lb", "pounds": "lb", "lbs": "lb", "ounce": "oz", "ounces": "oz",
+	"liter": "l", "litre": "l", "liters": "l", "litres": "l", "gallon": "gal", "gallons": "gal", "pint": "pt", "quart": "qt",
+	"kmh": "km/h", "kph": "km/h", "knot": "kn", "knots": "kn",
+	"sec": "s", "second": "s", "seconds": "s", "minute": "min", "minutes": "min", "hour": "h", "hours": "h", "days": "day", "weeks": "week", "years": "year",
+	"byte": "b", "bytes": "b", "bits": "bit",
+	"celsius": "c", "°c": "c", "fahrenheit": "f", "°f": "f", "kelvin": "k",
+}
+
+func UnitConverter() Tool {
+	return Tool{
+		Name:        "convert_units",
+		Description: "Converts a value between units of length, mass, volume, area, speed, time, data size and temperature (c, f, k). Use unit symbols such as km, mi, lb, kg, gal, l, km/h, mph, gib, c, f.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"value": {"type": "number"},
+				"from": {"type": "string", "description": "Unit of the value"},
+				"to": {"type": "string", "description": "Unit to convert to"}
+			},
+			"required": ["value", "from", "to"]
+		}`),
+		Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Value float64 `json:"value"`
+				From  string  `json:"from"`
+				To    string  `json:"to"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			result, err := ConvertUnits(args.Value, args.From, args.To)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s %s = %s %s", strconv.FormatFloat(args.Value, 'g', 15, 64), args.From, strconv.FormatFloat(result, 'g', 10, 64), args.To), nil
+		},
+	}
+}
+
+func lookupUnit(name string) (string, unit, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := unitAliases[name]; ok {
+		name = alias
+	}
+	u, ok := units[name]
+	return name, u, ok
+}
+
+// ConvertUnits converts value from one unit to another of the same kind.
+func ConvertUnits(value float64, from, to string) (float64, error) {
+	fromName, fromUnit, ok := lookupUnit(from)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+	toName, toUnit, ok := lookupUnit(to)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+	if fromUnit.Kind != toUnit.Kind {
+		return 0, fmt.Errorf("cannot convert %s (%s) to %s (%s)", from, fromUnit.Kind, to, toUnit.Kind)
+	}
+	if fromUnit.Kind == "temperature" {
+		return fromCelsius(toCelsius(value, fromName), toName), nil
+	}
+	return value * fromUnit.Factor / toUnit.Factor, nil
+}
+
+func toCelsius(value float64, unit string) float64 {
+	switch unit {
+	case "f":
+		return (value - 32) * 5 / 9
+	case "k":
+		return value - 273.15
+	}
+	return value
+}
+
+func fromCelsius(value float64, unit string) float64 {
+	switch unit {
+	case "f":
+		return value*9/5 + 32
+	case "k":
+		return value + 273.15
+	}
+	return value
+}
@@ -0,0 +1,55 @@
+package tools
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConvertUnits(t *testing.T) {
+	tests := []struct {
+		value    float64
+		from, to string
+		want     float64
+	}{
+		{1, "km", "m", 1000},
+		{1, "mi", "km", 1.609344},
+		{12, "inches", "ft", 1},
+		{1, "lb", "g", 453.59237},
+		{1, "gal", "l", 3.785411784},
+		{1, "ha", "m2", 10000},
+		{36, "km/h", "m/s", 10},
+		{90, "minutes", "h", 1.5},
+		{1, "GiB", "MiB", 1024},
+		{8, "bits", "b", 1},
+		{100, "c", "f", 212},
+		{32, "°F", "celsius", 0},
+		{0, "k", "c", -273.15},
+		{5, " M ", "cm", 500},
+	}
+	for _, tt := range tests {
+		got, err := ConvertUnits(tt.value, tt.from, tt.to)
+		if err != nil {
+			t.Errorf("ConvertUnits(%v, %q, %q) returned error: %v", tt.value, tt.from, tt.to, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-9*math.Max(1, math.Abs(tt.want)) {
+			t.Errorf("ConvertUnits(%v, %q, %q) = %v, want %v", tt.value, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestConvertUnitsErrors(t *testing.T) {
+	tests := []struct {
+		from, to string
+	}{
+		{"parsec", "m"},
+		{"m", "furlong"},
+		{"kg", "m"},
+		{"c", "s"},
+	}
+	for _, tt := range tests {
+		if got, err := ConvertUnits(1, tt.from, tt.to); err == nil {
+			t.Errorf("ConvertUnits(1, %q, %q) = %v, want an error", tt.from, tt.to, got)
+		}
+	}
+}
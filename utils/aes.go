@@ -8,12 +8,15 @@ import (
 	"errors"
 	"io"
 	"quokka-ai-bot/config"
+	"sync"
 )
 
-var encryption_key = []byte(config.Load().AesKey) // openssl rand -hex 32
+var encryption_key = sync.OnceValue(func() []byte { // openssl rand -hex 32. Read on first use, so packages importing utils can be tested without a config
+	return []byte(config.Load().AesKey)
+})
 
 func EncryptMessage(plaintext string) (string, error) {
-	block, err := aes.NewCipher(encryption_key) // creating a cipher
+	block, err := aes.NewCipher(encryption_key()) // creating a cipher
 	if err != nil {
 		return "", err
 	}
@@ -38,7 +41,7 @@ func DecryptMessage(encryptedtext string) (string, error) {
 		return "", err
 	}
 
-	block, err := aes.NewCipher(encryption_key)
+	block, err := aes.NewCipher(encryption_key())
 	if err != nil {
 		return "", err
 	}